@@ -8,6 +8,11 @@ var console *Console
 var gameState State
 var nextState State
 
+//transition state. while transition is non-nil, outgoingState is the state being transitioned away from.
+var transition Transition
+var nextTransition Transition
+var outgoingState State
+
 //Initializes the game State. Call before running the game loop.
 func InitState(m State) {
 	if gameState == nil {
@@ -31,6 +36,22 @@ func ChangeState(m State) {
 	}
 }
 
+//Like ChangeState(), but plays a transition effect over the next few frames instead of swapping
+//instantly. Both states render while the transition is running, but neither will get input or
+//updates. Once it finishes, an EV_TRANSITION_DONE event is emitted and the new state takes over
+//as normal. If t is nil this is just ChangeState().
+func ChangeStateWithTransition(m State, t Transition) {
+	if m != nil && nextState == nil {
+		nextTransition = t
+	}
+	ChangeState(m)
+}
+
+//Reports whether a state transition is currently playing.
+func IsTransitioning() bool {
+	return transition != nil
+}
+
 //Initializes the console. Returns a pointer to the console so the user can manipulate it manually
 //if they prefer. Returns nil if there was an error.
 func InitConsole(w, h int, glyphPath, fontPath, title string) (*Console, error) {
@@ -83,7 +104,7 @@ func GameLoop() error {
 		for event = sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch t := event.(type) {
 			case *sdl.QuitEvent:
-				gameState.Shutdown()
+				shutdownStates()
 				running = false
 			case *sdl.WindowEvent:
 				if t.Event == sdl.WINDOWEVENT_RESTORED {
@@ -95,6 +116,8 @@ func GameLoop() error {
 						debugger.HandleKeypress(t.Keysym.Sym)
 					} else if t.Keysym.Sym == sdl.K_F10 {
 						debugger.ToggleVisible()
+					} else if transition != nil {
+						//states don't get input during transitions
 					} else {
 						if d := gameState.GetDialog(); d == nil {
 							gameState.HandleKeypress(t.Keysym.Sym)
@@ -110,12 +133,17 @@ func GameLoop() error {
 			debugger.Update()
 		}
 
-		if d := gameState.GetDialog(); d == nil {
-			gameState.Update()
-		} else {
-			d.Update()
-			if d.Done() {
-				gameState.CloseDialog()
+		pollTileData()
+
+		if transition == nil {
+			if d := gameState.GetDialog(); d == nil {
+				gameState.Update()
+				updateECS()
+			} else {
+				d.Update()
+				if d.Done() {
+					gameState.CloseDialog()
+				}
 			}
 		}
 
@@ -128,15 +156,10 @@ func GameLoop() error {
 		}
 
 		//TODO: get console.Render() running in another thread (i think this is a good idea... maybe?)
-		if d := gameState.GetDialog(); d != nil {
-			d.Render()
-			if w := d.GetWindow(); w != nil {
-				w.Render()
-			}
-		}
-		gameState.Render()
-		if w := gameState.GetWindow(); w != nil {
-			w.Render()
+		if transition != nil {
+			renderTransition()
+		} else {
+			renderState(gameState)
 		}
 
 		if debug {
@@ -149,10 +172,26 @@ func GameLoop() error {
 		for e := popInternalEvent(); e != nil; e = popInternalEvent() {
 			switch e.ID {
 			case EV_QUIT:
-				gameState.Shutdown()
+				shutdownStates()
 				running = false
 			case EV_CHANGE_STATE:
-				gameState.Shutdown()
+				//if a transition is already going, the state it was leaving gets dropped
+				if outgoingState != nil {
+					outgoingState.Shutdown()
+					outgoingState = nil
+				}
+
+				if nextTransition != nil {
+					//old state hangs around until the transition is done with it
+					outgoingState = gameState
+					transition = nextTransition
+					transition.Init(console.Dims())
+					nextTransition = nil
+				} else {
+					gameState.Shutdown()
+					transition = nil
+				}
+
 				console.Clear()
 				gameState = nextState
 				nextState = nil
@@ -163,6 +202,54 @@ func GameLoop() error {
 	return nil
 }
 
+//Renders a state, its dialog and their windows to the console.
+func renderState(s State) {
+	if d := s.GetDialog(); d != nil {
+		d.Render()
+		if w := d.GetWindow(); w != nil {
+			w.Render()
+		}
+	}
+	s.Render()
+	if w := s.GetWindow(); w != nil {
+		w.Render()
+	}
+}
+
+//Renders the outgoing and incoming states to separate buffers and has the current transition
+//composite them onto the console. When the transition finishes, the outgoing state is shut down.
+func renderTransition() {
+	console.Clear()
+	renderState(outgoingState)
+	out := console.copyCanvas()
+
+	console.Clear()
+	renderState(gameState)
+	in := console.copyCanvas()
+
+	transition.Tick()
+	w, _ := console.Dims()
+	for i := range in {
+		console.setCell(i%w, i/w, transition.Composite(i%w, i/w, out, in))
+	}
+
+	if transition.Done() {
+		outgoingState.Shutdown()
+		outgoingState = nil
+		transition = nil
+		PushEvent(NewEvent(EV_TRANSITION_DONE, ""))
+	}
+}
+
+//Shuts down the current state, and the outgoing one if we're in the middle of a transition.
+func shutdownStates() {
+	if outgoingState != nil {
+		outgoingState.Shutdown()
+		outgoingState = nil
+	}
+	gameState.Shutdown()
+}
+
 //Defines a game state (level, menu, anything that can take input, update itself, render to screen.)
 type State interface {
 	HandleKeypress(sdl.Keycode)
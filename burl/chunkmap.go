@@ -0,0 +1,378 @@
+package burl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//Size of the chunks in a ChunkMap, in tiles. Chunks are square.
+const CHUNK_SIZE = 32
+
+//ChunkGenerator fills in a freshly made chunk. (cx, cy) is the chunk's position in chunks, so the tile
+//at (x, y) in the chunk is at (cx*CHUNK_SIZE + x, cy*CHUNK_SIZE + y) in the world. Generators should
+//only depend on the chunk position (and a seed), so chunks always come out the same.
+type ChunkGenerator func(cx, cy int, chunk *TileMap)
+
+//ChunkMap is a map with no edges, for worlds too big to hold in one TileMap. The world is split into
+//chunks, each a small TileMap, which are generated the first time anything touches them. Chunks far
+//from the focus (usually the camera or player, see SetFocus()) are evicted to disk and read back when
+//needed again. Coordinates can be any int, negative included.
+//
+//Tile queries, entity placement and ShadowCast() work just like a TileMap. Light from entities and items
+//reaches across chunk borders into any chunk that's been made, and chunks made later get the light from
+//lights already around them. Light isn't redone when a chunk is made though, so light that passed over a
+//chunk before it existed isn't blocked by the walls it turns out to have. Entities and items on evicted
+//chunks are kept in memory. LightManagers don't work on chunks.
+type ChunkMap struct {
+	KeepRadius int //chunks further than this from the focus chunk (in chunks) are evicted. default 2
+
+	chunks   map[Coord]*chunk
+	generate ChunkGenerator
+	dir      string
+}
+
+type chunk struct {
+	tileMap *TileMap //nil when evicted
+	saved   []byte   //evicted chunk, if there's no directory to put it in
+	onDisk  bool
+	things  []mapThing
+}
+
+//Creates a chunk map. Evicted chunks are saved as files in dir, or kept in memory in the compact saved
+//map format if dir is "".
+func NewChunkMap(gen ChunkGenerator, dir string) *ChunkMap {
+	return &ChunkMap{KeepRadius: 2, chunks: make(map[Coord]*chunk), generate: gen, dir: dir}
+}
+
+//Splits a world coordinate into chunk coordinate and position in the chunk. Rounds down, so -1 is in
+//chunk -1, not 0.
+func chunkSplit(n int) (c, local int) {
+	c = n / CHUNK_SIZE
+	if n%CHUNK_SIZE < 0 {
+		c--
+	}
+	return c, n - c*CHUNK_SIZE
+}
+
+//Returns the chunk containing world coord (x, y), loading or generating it if needed, and the position
+//in the chunk.
+func (cm *ChunkMap) locate(x, y int) (*TileMap, int, int) {
+	cx, lx := chunkSplit(x)
+	cy, ly := chunkSplit(y)
+	return cm.GetChunk(cx, cy), lx, ly
+}
+
+//Returns the chunk at chunk position (cx, cy), loading or generating it if needed.
+func (cm *ChunkMap) GetChunk(cx, cy int) *TileMap {
+	pos := Coord{cx, cy}
+	c := cm.chunks[pos]
+	if c == nil {
+		c = new(chunk)
+		cm.chunks[pos] = c
+	}
+	if c.tileMap != nil {
+		return c.tileMap
+	}
+
+	if c.onDisk || c.saved != nil {
+		m, err := cm.readChunk(pos, c)
+		if err == nil {
+			m.world, m.origin = cm, Coord{cx * CHUNK_SIZE, cy * CHUNK_SIZE}
+			m.putThings(c.things)
+			c.tileMap, c.saved, c.onDisk, c.things = m, nil, false, nil
+			return m
+		}
+		LogError("Could not load chunk (", cx, ", ", cy, "), regenerating it: ", err.Error())
+	}
+
+	c.tileMap = NewMap(CHUNK_SIZE, CHUNK_SIZE)
+	c.tileMap.world, c.tileMap.origin = cm, Coord{cx * CHUNK_SIZE, cy * CHUNK_SIZE}
+	if cm.generate != nil {
+		cm.generate(cx, cy, c.tileMap)
+	}
+	cm.lightChunk(pos, c.tileMap)
+	c.putBack()
+	return c.tileMap
+}
+
+//Puts anything that was on a chunk that failed to load onto its regenerated replacement, so it isn't lost.
+func (c *chunk) putBack() {
+	for _, t := range c.things {
+		if t.item != nil {
+			c.tileMap.AddItem(t.x, t.y, t.item)
+		} else {
+			c.tileMap.AddEntity(t.x, t.y, t.entity)
+		}
+	}
+	c.saved, c.onDisk, c.things = nil, false, nil
+}
+
+//Reports whether the chunk at (cx, cy) is in memory.
+func (cm *ChunkMap) IsLoaded(cx, cy int) bool {
+	c := cm.chunks[Coord{cx, cy}]
+	return c != nil && c.tileMap != nil
+}
+
+//Returns the number of chunks in memory.
+func (cm *ChunkMap) LoadedChunks() (n int) {
+	for _, c := range cm.chunks {
+		if c.tileMap != nil {
+			n++
+		}
+	}
+	return
+}
+
+//Sets the focus of the map to world coord (x, y) and evicts chunks more than KeepRadius chunks away
+//from it. Call this when the camera moves.
+func (cm *ChunkMap) SetFocus(x, y int) {
+	cx, _ := chunkSplit(x)
+	cy, _ := chunkSplit(y)
+
+	for pos, c := range cm.chunks {
+		if c.tileMap != nil && Max(Abs(pos.X-cx), Abs(pos.Y-cy)) > cm.KeepRadius {
+			if err := cm.Evict(pos.X, pos.Y); err != nil {
+				LogError("Could not evict chunk (", pos.X, ", ", pos.Y, "): ", err.Error())
+			}
+		}
+	}
+}
+
+//Evicts the chunk at (cx, cy), saving it to disk (or memory). It's read back next time it's needed.
+func (cm *ChunkMap) Evict(cx, cy int) error {
+	pos := Coord{cx, cy}
+	c := cm.chunks[pos]
+	if c == nil || c.tileMap == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := SaveMap(&b, c.tileMap); err != nil {
+		return err
+	}
+
+	if cm.dir != "" {
+		if err := os.MkdirAll(cm.dir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(cm.chunkPath(pos), b.Bytes(), 0644); err != nil {
+			return err
+		}
+		c.onDisk = true
+	} else {
+		c.saved = b.Bytes()
+	}
+
+	c.things = c.tileMap.takeThings()
+	c.tileMap = nil
+	return nil
+}
+
+func (cm *ChunkMap) chunkPath(pos Coord) string {
+	return filepath.Join(cm.dir, fmt.Sprintf("chunk_%d_%d.map", pos.X, pos.Y))
+}
+
+func (cm *ChunkMap) readChunk(pos Coord, c *chunk) (*TileMap, error) {
+	data := c.saved
+	if c.onDisk {
+		var err error
+		if data, err = ioutil.ReadFile(cm.chunkPath(pos)); err != nil {
+			return nil, err
+		}
+	}
+
+	m, err := LoadMap(bytes.NewReader(data))
+	if err == nil && c.onDisk {
+		//the chunk is rewritten next time it's evicted, so the file is just clutter now
+		if err := os.Remove(cm.chunkPath(pos)); err != nil {
+			LogError("Could not remove chunk file: ", err.Error())
+		}
+	}
+	return m, err
+}
+
+func (cm *ChunkMap) GetTileType(x, y int) int {
+	m, lx, ly := cm.locate(x, y)
+	return m.GetTileType(lx, ly)
+}
+
+func (cm *ChunkMap) ChangeTileType(x, y, tile int) {
+	m, lx, ly := cm.locate(x, y)
+	m.ChangeTileType(lx, ly, tile)
+}
+
+func (cm *ChunkMap) GetTile(x, y int) Tile {
+	m, lx, ly := cm.locate(x, y)
+	return m.GetTile(lx, ly)
+}
+
+func (cm *ChunkMap) SetTile(x, y int, t Tile) {
+	m, lx, ly := cm.locate(x, y)
+	m.SetTile(lx, ly, t)
+}
+
+func (cm *ChunkMap) LastVisible(x, y int) int {
+	m, lx, ly := cm.locate(x, y)
+	return m.LastVisible(lx, ly)
+}
+
+func (cm *ChunkMap) SetVisible(x, y, tick int) {
+	m, lx, ly := cm.locate(x, y)
+	m.SetVisible(lx, ly, tick)
+}
+
+//Adds an entity to the tile at (x, y). Same rules as TileMap.AddEntity().
+func (cm *ChunkMap) AddEntity(x, y int, e Entity) {
+	m, lx, ly := cm.locate(x, y)
+	m.AddEntity(lx, ly, e)
+}
+
+//Removes the blocking entity from the tile at (x, y), if there is one.
+func (cm *ChunkMap) RemoveEntity(x, y int) {
+	m, lx, ly := cm.locate(x, y)
+	m.RemoveEntity(lx, ly)
+}
+
+//Removes the particular entity e from the tile at (x, y).
+func (cm *ChunkMap) RemoveEntityInstance(x, y int, e Entity) {
+	m, lx, ly := cm.locate(x, y)
+	m.RemoveEntityInstance(lx, ly, e)
+}
+
+//Moves the blocking entity at (x, y) by (dx, dy), across chunk borders if need be.
+func (cm *ChunkMap) MoveEntity(x, y, dx, dy int) {
+	if e := cm.GetEntity(x, y); e != nil {
+		cm.MoveEntityInstance(x, y, dx, dy, e)
+	}
+}
+
+//Moves the particular entity e on tile (x, y) by (dx, dy). Same rules as TileMap.MoveEntityInstance().
+func (cm *ChunkMap) MoveEntityInstance(x, y, dx, dy int, e Entity) {
+	if !cm.HasEntity(x, y, e) || (isBlocking(e) && cm.GetEntity(x+dx, y+dy) != nil) {
+		return
+	}
+
+	cm.RemoveEntityInstance(x, y, e)
+	cm.AddEntity(x+dx, y+dy, e)
+}
+
+func (cm *ChunkMap) GetEntity(x, y int) Entity {
+	m, lx, ly := cm.locate(x, y)
+	return m.GetEntity(lx, ly)
+}
+
+func (cm *ChunkMap) GetEntities(x, y int) []Entity {
+	m, lx, ly := cm.locate(x, y)
+	return m.GetEntities(lx, ly)
+}
+
+func (cm *ChunkMap) HasEntity(x, y int, e Entity) bool {
+	m, lx, ly := cm.locate(x, y)
+	return m.HasEntity(lx, ly, e)
+}
+
+func (cm *ChunkMap) AddItem(x, y int, i Item) {
+	m, lx, ly := cm.locate(x, y)
+	m.AddItem(lx, ly, i)
+}
+
+func (cm *ChunkMap) RemoveItem(x, y int, i Item) {
+	m, lx, ly := cm.locate(x, y)
+	m.RemoveItem(lx, ly, i)
+}
+
+func (cm *ChunkMap) GetItems(x, y int) []Item {
+	m, lx, ly := cm.locate(x, y)
+	return m.GetItems(lx, ly)
+}
+
+//Runs the shadowcaster over the chunk map, across chunk borders. Chunks in range are loaded or
+//generated as needed. fn gets the chunk each tile is on and the tile's position in that chunk, so
+//existing Casts like LightenColour() work as they are. Use WorldCoords() to get the world position.
+//See TileMap.ShadowCast().
+func (cm *ChunkMap) ShadowCast(x, y, radius int, fn Cast) {
+	cm.cast(cm, x, y, radius, fn)
+}
+
+//Converts a position on one of the map's chunks, like the ones a Cast gets, into world coords.
+func (cm *ChunkMap) WorldCoords(chunk *TileMap, x, y int) (int, int) {
+	return chunk.origin.X + x, chunk.origin.Y + y
+}
+
+func (cm *ChunkMap) cast(g shadowGrid, x, y, radius int, fn Cast) {
+	if radius <= 0 || !g.inBounds(x, y) {
+		return
+	}
+	visit := func(wx, wy, d int) {
+		m, lx, ly := cm.locate(wx, wy)
+		fn(m, lx, ly, d, radius)
+	}
+	visit(x, y, 0)
+	for i := 0; i < 8; i++ {
+		scan(g, x, y, 1, 1.0, 0.0, radius, rMatrix[i], i%2 == 0, visit)
+	}
+}
+
+func (cm *ChunkMap) inBounds(x, y int) bool {
+	return true
+}
+
+func (cm *ChunkMap) transparentAt(x, y int) bool {
+	return cm.GetTile(x, y).Transparent()
+}
+
+//The chunks that have been made so far. Light is cast over these, so placing a light doesn't generate
+//the chunks around it (whose lights would generate the chunks around them, and so on forever).
+type madeChunks struct {
+	*ChunkMap
+}
+
+func (mc madeChunks) inBounds(x, y int) bool {
+	cx, _ := chunkSplit(x)
+	cy, _ := chunkSplit(y)
+	return mc.chunks[Coord{cx, cy}] != nil
+}
+
+//Casts light (or darkness) from world coord (x, y) over the chunks that have been made.
+func (cm *ChunkMap) castLight(x, y, radius int, fn Cast) {
+	cm.cast(madeChunks{cm}, x, y, radius, fn)
+}
+
+//Lights a freshly made chunk with the lights on the chunks around it.
+func (cm *ChunkMap) lightChunk(pos Coord, m *TileMap) {
+	for p, c := range cm.chunks {
+		if p == pos {
+			continue
+		}
+		things := c.things
+		if c.tileMap != nil {
+			things = c.tileMap.takeThings()
+		}
+		for _, t := range things {
+			var le LightEmitter
+			if t.item != nil {
+				le, _ = t.item.(LightEmitter)
+			} else {
+				le, _ = t.entity.(LightEmitter)
+			}
+			if le == nil {
+				continue
+			}
+
+			l := le.GetLight()
+			x, y := p.X*CHUNK_SIZE+t.x, p.Y*CHUNK_SIZE+t.y
+			if x+l.Strength < m.origin.X || x-l.Strength >= m.origin.X+CHUNK_SIZE || y+l.Strength < m.origin.Y || y-l.Strength >= m.origin.Y+CHUNK_SIZE {
+				continue
+			}
+			light := LightenColour(l.Colour, l.Falloff)
+			cm.castLight(x, y, l.Strength, func(c *TileMap, x, y, d, r int) {
+				if c == m {
+					light(c, x, y, d, r)
+				}
+			})
+		}
+	}
+}
@@ -42,16 +42,36 @@ func BlendColours(c1, c2 uint32, mode BlendMode) uint32 {
 		g = 255-int(255-g1)*int(255-g2)/255
 		b = 255-int(255-b1)*int(255-b2)/255
 		a = 255-int(255-a1)*int(255-a2)/255
+	case BLEND_ADD:
+		r = Min(int(r1)+int(r2), 255)
+		g = Min(int(g1)+int(g2), 255)
+		b = Min(int(b1)+int(b2), 255)
+		a = Min(int(a1)+int(a2), 255)
 	}
 
 	return MakeColour(r, g, b, a)
 }
 
+//Linearly interpolates between colours c1 and c2 over (steps) intervals, returning the (val)th step.
+//Alpha is interpolated too.
+func LerpColour(c1, c2 uint32, val, steps int) uint32 {
+	r1, g1, b1, a1 := GetRGBA(c1)
+	r2, g2, b2, a2 := GetRGBA(c2)
+
+	r := Lerp(int(r1), int(r2), val, steps)
+	g := Lerp(int(g1), int(g2), val, steps)
+	b := Lerp(int(b1), int(b2), val, steps)
+	a := Lerp(int(a1), int(a2), val, steps)
+
+	return MakeColour(r, g, b, a)
+}
+
 type BlendMode int 
 
 const (
 	BLEND_MULTIPLY BlendMode = iota
 	BLEND_SCREEN
+	BLEND_ADD //adds the components together, clamping at 255. for mixing lights.
 )
 
 const (
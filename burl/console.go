@@ -337,6 +337,21 @@ func (c *Console) GetCell(x, y int) *Cell {
 	return nil
 }
 
+//Returns a copy of the canvas as it currently stands. Used for compositing (see transitions).
+func (c *Console) copyCanvas() []Cell {
+	buf := make([]Cell, len(c.canvas))
+	copy(buf, c.canvas)
+	return buf
+}
+
+//Replaces the cell at (x, y) wholesale, ignoring z-levels. Always marks the cell for redraw.
+func (c *Console) setCell(x, y int, cell Cell) {
+	if CheckBounds(x, y, c.width, c.height) {
+		cell.Dirty = true
+		c.canvas[y*c.width+x] = cell
+	}
+}
+
 //Changes the glyph of a cell in the canvas at position (x, y).
 func (c *Console) ChangeGlyph(x, y, glyph int) {
 	s := y*c.width + x
@@ -580,6 +595,76 @@ func (c *Console) Fill(x, y, z, w, h, g int, fore, back uint32) {
 	}
 }
 
+func (c *Console) DrawCircle(x, y, z, r, glyph int, fore, back uint32) {
+	DrawCircle(Coord{x, y}, r, c.drawFunc(z, glyph, fore, back))
+}
+
+func (c *Console) DrawFilledCircle(x, y, z, r, glyph int, fore, back uint32) {
+	DrawFilledCircle(Coord{x, y}, r, c.drawFunc(z, glyph, fore, back))
+}
+
+func (c *Console) DrawEllipse(x, y, z, rx, ry, glyph int, fore, back uint32) {
+	DrawEllipse(Coord{x, y}, rx, ry, c.drawFunc(z, glyph, fore, back))
+}
+
+func (c *Console) DrawFilledEllipse(x, y, z, rx, ry, glyph int, fore, back uint32) {
+	DrawFilledEllipse(Coord{x, y}, rx, ry, c.drawFunc(z, glyph, fore, back))
+}
+
+//Draws a line from (x1, y1) to (x2, y2) at depth z.
+func (c *Console) DrawLine(x1, y1, x2, y2, z, glyph int, fore, back uint32) {
+	DrawLine(Coord{x1, y1}, Coord{x2, y2}, c.drawFunc(z, glyph, fore, back))
+}
+
+//Draws the outline of a rect. For filled rects, see Fill().
+func (c *Console) DrawRect(x, y, z, w, h, glyph int, fore, back uint32) {
+	DrawRect(Rect{w, h, x, y}, c.drawFunc(z, glyph, fore, back))
+}
+
+func (c *Console) DrawPolygon(points []Coord, z, glyph int, fore, back uint32) {
+	DrawPolygon(points, c.drawFunc(z, glyph, fore, back))
+}
+
+func (c *Console) DrawFilledPolygon(points []Coord, z, glyph int, fore, back uint32) {
+	DrawFilledPolygon(points, c.drawFunc(z, glyph, fore, back))
+}
+
+//Flood fills the area of identical glyph cells connected to (x, y) with the provided visuals. Cells
+//above depth z block the fill.
+func (c *Console) FloodFill(x, y, z, glyph int, fore, back uint32) {
+	cell := c.GetCell(x, y)
+	if cell == nil || cell.Z > z || cell.Mode == DRAW_TEXT {
+		return
+	}
+
+	target := *cell
+	c.ChangeCell(x, y, z, glyph, fore, back)
+	if sameVisuals(*cell, target) {
+		return //nothing changed, so filling would go on forever
+	}
+	*cell = target
+
+	FloodFill(Coord{x, y},
+		func(x, y int) bool {
+			cell := c.GetCell(x, y)
+			return cell != nil && cell.Z <= z && cell.Mode == DRAW_GLYPH && sameVisuals(*cell, target)
+		},
+		c.drawFunc(z, glyph, fore, back))
+}
+
+//Returns a function that draws the provided visuals to the console. Used with the drawing functions
+//in draw.go.
+func (c *Console) drawFunc(z, glyph int, fore, back uint32) func(x, y int) {
+	return func(x, y int) {
+		c.ChangeCell(x, y, z, glyph, fore, back)
+	}
+}
+
+//Reports whether two cells look the same when drawn in glyph mode.
+func sameVisuals(c1, c2 Cell) bool {
+	return c1.Glyph == c2.Glyph && c1.ForeColour == c2.ForeColour && c1.BackColour == c2.BackColour
+}
+
 //Returns the dimensions of the canvas.
 func (c *Console) Dims() (w, h int) {
 	return c.width, c.height
@@ -1,5 +1,10 @@
 package burl
 
+import (
+	"math"
+	"sort"
+)
+
 //returns a generator that computes successive coordinates representing 1/8th of a circle. rotate
 //the arc to draw circles. gives back the ZERO_COORD when it is done.
 func ArcGenerator(radius int) func() Coord {
@@ -41,3 +46,280 @@ func DrawCircle(center Coord, radius int, fn func(x, y int)) {
 		fn(center.X+p.X, center.Y-p.Y)
 	}
 }
+
+//Computes a filled circle, calling fn on each point inside (and on) the circle. Uses the same arc as
+//DrawCircle() so the edges match up.
+func DrawFilledCircle(center Coord, radius int, fn func(x, y int)) {
+	if radius < 0 {
+		return
+	}
+
+	//find the half-width of each row
+	rows := make([]int, radius+1)
+	c := ArcGenerator(radius)
+	for p := c(); p != ZERO_COORD; p = c() {
+		rows[p.Y] = Max(rows[p.Y], p.X)
+		rows[p.X] = Max(rows[p.X], p.Y)
+	}
+
+	fillSpans(center, rows, fn)
+}
+
+//Computes an ellipse with horizontal radius rx and vertical radius ry, calling fn on each point of
+//the outline. Uses the midpoint ellipse algorithm.
+func DrawEllipse(center Coord, rx, ry int, fn func(x, y int)) {
+	if rx == 0 || ry == 0 {
+		DrawLine(Coord{center.X - rx, center.Y - ry}, Coord{center.X + rx, center.Y + ry}, fn)
+		return
+	}
+
+	ellipseQuadrant(rx, ry, func(x, y int) {
+		fn(center.X+x, center.Y+y)
+		if x != 0 {
+			fn(center.X-x, center.Y+y)
+		}
+		if y != 0 {
+			fn(center.X+x, center.Y-y)
+			if x != 0 {
+				fn(center.X-x, center.Y-y)
+			}
+		}
+	})
+}
+
+//Computes a filled ellipse, calling fn on each point inside (and on) the ellipse.
+func DrawFilledEllipse(center Coord, rx, ry int, fn func(x, y int)) {
+	if rx == 0 || ry == 0 {
+		DrawEllipse(center, rx, ry, fn)
+		return
+	}
+
+	rows := make([]int, ry+1)
+	ellipseQuadrant(rx, ry, func(x, y int) {
+		rows[y] = Max(rows[y], x)
+	})
+
+	fillSpans(center, rows, fn)
+}
+
+//Runs the midpoint ellipse algorithm over one quadrant, calling fn with offsets from the center.
+func ellipseQuadrant(rx, ry int, fn func(x, y int)) {
+	rx2, ry2 := float64(rx*rx), float64(ry*ry)
+	x, y := 0, ry
+	dx, dy := 0.0, 2*rx2*float64(y)
+
+	//region 1, slope > -1
+	p := ry2 - rx2*float64(ry) + rx2/4
+	for dx < dy {
+		fn(x, y)
+		x++
+		dx += 2 * ry2
+		if p < 0 {
+			p += dx + ry2
+		} else {
+			y--
+			dy -= 2 * rx2
+			p += dx - dy + ry2
+		}
+	}
+
+	//region 2, slope < -1
+	p = ry2*(float64(x)+0.5)*(float64(x)+0.5) + rx2*float64((y-1)*(y-1)) - rx2*ry2
+	for y >= 0 {
+		fn(x, y)
+		y--
+		dy -= 2 * rx2
+		if p > 0 {
+			p += rx2 - dy
+		} else {
+			x++
+			dx += 2 * ry2
+			p += dx - dy + rx2
+		}
+	}
+}
+
+//Calls fn on every point of a shape symmetric around center, where rows[i] holds the half-width
+//of the rows i above and below the center.
+func fillSpans(center Coord, rows []int, fn func(x, y int)) {
+	for dy, w := range rows {
+		for dx := -w; dx <= w; dx++ {
+			fn(center.X+dx, center.Y+dy)
+			if dy != 0 {
+				fn(center.X+dx, center.Y-dy)
+			}
+		}
+	}
+}
+
+//Computes a line from start to end using Bresenham's algorithm, calling fn on each point. Both
+//endpoints are included.
+func DrawLine(start, end Coord, fn func(x, y int)) {
+	dx, dy := Abs(end.X-start.X), -Abs(end.Y-start.Y)
+	sx, sy := 1, 1
+	if start.X > end.X {
+		sx = -1
+	}
+	if start.Y > end.Y {
+		sy = -1
+	}
+
+	err := dx + dy
+	x, y := start.X, start.Y
+	for {
+		fn(x, y)
+		if x == end.X && y == end.Y {
+			return
+		}
+
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y += sy
+		}
+	}
+}
+
+//Computes a "supercover" line from start to end, calling fn on every point the line passes through,
+//not just one per step like DrawLine(). Where the line passes exactly through a corner, both tiles
+//beside the corner are included (in x then y order) before the diagonal one. Both endpoints are
+//included.
+func DrawSupercoverLine(start, end Coord, fn func(x, y int)) {
+	nx, ny := Abs(end.X-start.X), Abs(end.Y-start.Y)
+	sx, sy := sign(end.X-start.X), sign(end.Y-start.Y)
+
+	x, y := start.X, start.Y
+	fn(x, y)
+	for ix, iy := 0, 0; ix < nx || iy < ny; {
+		//compare where the line crosses the next vertical and horizontal tile edges
+		switch d := (1+2*ix)*ny - (1+2*iy)*nx; {
+		case d == 0:
+			fn(x+sx, y)
+			fn(x, y+sy)
+			x, y = x+sx, y+sy
+			ix, iy = ix+1, iy+1
+		case d < 0:
+			x += sx
+			ix++
+		default:
+			y += sy
+			iy++
+		}
+		fn(x, y)
+	}
+}
+
+//Computes the outline of a rectangle, calling fn on each point.
+func DrawRect(r Rect, fn func(x, y int)) {
+	if r.W <= 0 || r.H <= 0 {
+		return
+	}
+
+	for i := 0; i < r.W; i++ {
+		fn(r.X+i, r.Y)
+		if r.H > 1 {
+			fn(r.X+i, r.Y+r.H-1)
+		}
+	}
+	for j := 1; j < r.H-1; j++ {
+		fn(r.X, r.Y+j)
+		if r.W > 1 {
+			fn(r.X+r.W-1, r.Y+j)
+		}
+	}
+}
+
+//Computes a filled rectangle, calling fn on each point.
+func DrawFilledRect(r Rect, fn func(x, y int)) {
+	for i := 0; i < r.W*r.H; i++ {
+		fn(r.X+i%r.W, r.Y+i/r.W)
+	}
+}
+
+//Computes the outline of a closed polygon with the provided vertices, calling fn on each point.
+//The last vertex is connected back to the first.
+func DrawPolygon(points []Coord, fn func(x, y int)) {
+	if len(points) == 0 {
+		return
+	}
+
+	visited := make(map[Coord]bool)
+	for i := range points {
+		DrawLine(points[i], points[(i+1)%len(points)], func(x, y int) {
+			if !visited[Coord{x, y}] {
+				visited[Coord{x, y}] = true
+				fn(x, y)
+			}
+		})
+	}
+}
+
+//Computes a filled polygon with the provided vertices, calling fn on each point. Uses the even-odd
+//rule for self-intersecting polygons. The outline is always included.
+func DrawFilledPolygon(points []Coord, fn func(x, y int)) {
+	if len(points) == 0 {
+		return
+	}
+
+	visited := make(map[Coord]bool)
+	DrawPolygon(points, func(x, y int) {
+		visited[Coord{x, y}] = true
+		fn(x, y)
+	})
+
+	minY, maxY := points[0].Y, points[0].Y
+	for _, p := range points {
+		minY, maxY = Min(minY, p.Y), Max(maxY, p.Y)
+	}
+
+	//scanline fill, finding where each row crosses the edges of the polygon.
+	crossings := make([]float64, 0, len(points))
+	for y := minY; y <= maxY; y++ {
+		crossings = crossings[:0]
+		for i := range points {
+			p1, p2 := points[i], points[(i+1)%len(points)]
+			if (p1.Y <= y && y < p2.Y) || (p2.Y <= y && y < p1.Y) {
+				crossings = append(crossings, float64(p1.X)+float64(y-p1.Y)*float64(p2.X-p1.X)/float64(p2.Y-p1.Y))
+			}
+		}
+		sort.Float64s(crossings)
+
+		for i := 0; i+1 < len(crossings); i += 2 {
+			for x := int(math.Ceil(crossings[i])); x <= int(math.Floor(crossings[i+1])); x++ {
+				if !visited[Coord{x, y}] {
+					visited[Coord{x, y}] = true
+					fn(x, y)
+				}
+			}
+		}
+	}
+}
+
+//Computes a 4-way flood fill starting at start. Spreads to any point for which test returns true,
+//calling fn on each. test is never called more than once on a point, and must return false for
+//points out of bounds or the fill will never end!
+func FloodFill(start Coord, test func(x, y int) bool, fn func(x, y int)) {
+	visited := map[Coord]bool{start: true}
+	queue := []Coord{start}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		if !test(c.X, c.Y) {
+			continue
+		}
+		fn(c.X, c.Y)
+
+		for _, n := range [4]Coord{{c.X + 1, c.Y}, {c.X - 1, c.Y}, {c.X, c.Y + 1}, {c.X, c.Y - 1}} {
+			if !visited[n] {
+				visited[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+}
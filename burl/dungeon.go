@@ -0,0 +1,587 @@
+package burl
+
+import (
+	"math/rand"
+	"sort"
+)
+
+//Tile types for the dungeon generators to build with. If Door is 0, no doors are made.
+type DungeonTiles struct {
+	Floor, Wall, Door int
+}
+
+//Dungeon describes the layout produced by a generator: where the rooms are, where the doors ended up
+//and which rooms connect to which. The generators are seeded, so the same seed and options on the
+//same size of map always produce the same dungeon.
+type Dungeon struct {
+	Seed  int64
+	Start Coord //a good place to put the player. always a floor tile.
+	Rooms []Rect
+	Doors []Coord
+	Graph [][]int //Graph[i] lists the rooms connected to room i, in ascending order
+}
+
+func newDungeon(seed int64) *Dungeon {
+	return &Dungeon{Seed: seed}
+}
+
+//Returns the index of the room containing (x, y), or -1 if it isn't in a room.
+func (d *Dungeon) RoomAt(x, y int) int {
+	for i, r := range d.Rooms {
+		if IsInside(x, y, r) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *Dungeon) addRoom(r Rect) {
+	d.Rooms = append(d.Rooms, r)
+	d.Graph = append(d.Graph, nil)
+}
+
+//Records that rooms a and b are connected.
+func (d *Dungeon) connect(a, b int) {
+	if a == b || a < 0 || b < 0 {
+		return
+	}
+
+	for _, n := range d.Graph[a] {
+		if n == b {
+			return
+		}
+	}
+	d.Graph[a] = append(d.Graph[a], b)
+	d.Graph[b] = append(d.Graph[b], a)
+	sort.Ints(d.Graph[a])
+	sort.Ints(d.Graph[b])
+}
+
+func (d *Dungeon) addDoor(c Coord) {
+	for _, door := range d.Doors {
+		if door == c {
+			return
+		}
+	}
+	d.Doors = append(d.Doors, c)
+}
+
+//Centre of room i.
+func (d *Dungeon) roomCentre(i int) Coord {
+	r := d.Rooms[i]
+	return Coord{r.X + r.W/2, r.Y + r.H/2}
+}
+
+//Fills the whole map with a single tile type.
+func (m *TileMap) fillTiles(tile int) {
+	for i := range m.Tiles {
+		m.ChangeTileType(i%m.Width, i/m.Width, tile)
+	}
+}
+
+//Puts Start on the floor tile closest to the centre of the first room. Any passable tile that isn't a
+//door counts, so prefabs can use their own floors. Returns false if there's nowhere to put it.
+func (d *Dungeon) placeStart(m *TileMap, tiles DungeonTiles) bool {
+	if len(d.Rooms) == 0 {
+		return false
+	}
+
+	var floors []Coord
+	for i, t := range m.Tiles {
+		if IsPassable(t.TileType) && (tiles.Door == 0 || t.TileType != tiles.Door) {
+			floors = append(floors, Coord{i % m.Width, i / m.Width})
+		}
+	}
+	if len(floors) == 0 {
+		return false
+	}
+
+	d.Start = closestTo(floors, d.roomCentre(0))
+	return true
+}
+
+//Fills a rect of the map with a single tile type.
+func (m *TileMap) fillRect(r Rect, tile int) {
+	for y := r.Y; y < r.Y+r.H; y++ {
+		for x := r.X; x < r.X+r.W; x++ {
+			m.ChangeTileType(x, y, tile)
+		}
+	}
+}
+
+//Digs an L-shaped corridor from a to b. Solid tiles in the way are dug out, even in rooms (so prefab
+//walls get knocked through). Where the corridor crosses into or out of a room a door is made: if the
+//room's edge was solid the door goes there, otherwise on the corridor tile next to the room. Doors
+//aren't put right next to other doors. Rooms the corridor passes through are connected in the graph.
+func (m *TileMap) digCorridor(d *Dungeon, a, b Coord, tiles DungeonTiles, rng *rand.Rand) {
+	var path []Coord
+	horizontalFirst := rng.Intn(2) == 0
+	x, y := a.X, a.Y
+	path = append(path, Coord{x, y})
+	for x != b.X || y != b.Y {
+		if (horizontalFirst && x != b.X) || y == b.Y {
+			x += sign(b.X - x)
+		} else {
+			y += sign(b.Y - y)
+		}
+		path = append(path, Coord{x, y})
+	}
+
+	rooms := make([]int, len(path))
+	solid := make([]bool, len(path))
+	for i, p := range path {
+		rooms[i] = d.RoomAt(p.X, p.Y)
+		solid[i] = !IsPassable(m.GetTileType(p.X, p.Y))
+	}
+
+	lastRoom := -1
+	for i, p := range path {
+		if rooms[i] >= 0 {
+			d.connect(lastRoom, rooms[i])
+			lastRoom = rooms[i]
+		}
+		if solid[i] {
+			m.ChangeTileType(p.X, p.Y, tiles.Floor)
+		}
+
+		//crossing a room edge?
+		if i == 0 || rooms[i] == rooms[i-1] {
+			continue
+		}
+		for _, pair := range [][2]int{{i, i - 1}, {i - 1, i}} {
+			in, out := pair[0], pair[1]
+			if rooms[in] == -1 || rooms[out] != -1 {
+				continue
+			}
+			door := path[out]
+			if solid[in] {
+				door = path[in]
+			}
+			if tiles.Door != 0 && !d.doorNear(door) {
+				d.addDoor(door)
+				m.ChangeTileType(door.X, door.Y, tiles.Door)
+			}
+		}
+	}
+}
+
+//Reports whether there's a door next to c (4-way).
+func (d *Dungeon) doorNear(c Coord) bool {
+	for _, door := range d.Doors {
+		if Abs(door.X-c.X)+Abs(door.Y-c.Y) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+//Options for GenerateBSP(). Zero values get sensible defaults.
+type BSPOptions struct {
+	MinRoomSize int //default 4
+	MaxRoomSize int //default 10
+}
+
+//Generates classic rooms-and-corridors by recursively splitting the map into smaller and smaller
+//rectangles, putting a room in each, and joining sibling rectangles with corridors. Everything is
+//connected. Returns nil if the map is too small for any rooms.
+func (m *TileMap) GenerateBSP(seed int64, tiles DungeonTiles, opts BSPOptions) *Dungeon {
+	rng := rand.New(rand.NewSource(seed))
+	d := newDungeon(seed)
+	if opts.MinRoomSize <= 0 {
+		opts.MinRoomSize = 4
+	}
+	if opts.MaxRoomSize < opts.MinRoomSize {
+		opts.MaxRoomSize = Max(10, opts.MinRoomSize)
+	}
+
+	m.fillTiles(tiles.Wall)
+	m.bspSplit(d, Rect{m.Width - 2, m.Height - 2, 1, 1}, opts, tiles, rng)
+	if !d.placeStart(m, tiles) {
+		return nil
+	}
+
+	return d
+}
+
+//Splits leaf, recursing until it's too small to split, then builds a room in it. Returns the indices
+//of the rooms built inside leaf.
+func (m *TileMap) bspSplit(d *Dungeon, leaf Rect, opts BSPOptions, tiles DungeonTiles, rng *rand.Rand) []int {
+	minLeaf := opts.MinRoomSize + 2
+	maxLeaf := opts.MaxRoomSize + 2
+
+	splitH := leaf.W >= 2*minLeaf && (leaf.W > maxLeaf || rng.Intn(3) == 0)
+	splitV := leaf.H >= 2*minLeaf && (leaf.H > maxLeaf || rng.Intn(3) == 0)
+	if splitH && splitV {
+		splitH = leaf.W > leaf.H || (leaf.W == leaf.H && rng.Intn(2) == 0)
+		splitV = !splitH
+	}
+
+	var a, b Rect
+	switch {
+	case splitH:
+		s := minLeaf + rng.Intn(leaf.W-2*minLeaf+1)
+		a, b = Rect{s, leaf.H, leaf.X, leaf.Y}, Rect{leaf.W - s, leaf.H, leaf.X + s, leaf.Y}
+	case splitV:
+		s := minLeaf + rng.Intn(leaf.H-2*minLeaf+1)
+		a, b = Rect{leaf.W, s, leaf.X, leaf.Y}, Rect{leaf.W, leaf.H - s, leaf.X, leaf.Y + s}
+	default:
+		if leaf.W < minLeaf || leaf.H < minLeaf {
+			return nil
+		}
+		w := opts.MinRoomSize + rng.Intn(Min(opts.MaxRoomSize, leaf.W-2)-opts.MinRoomSize+1)
+		h := opts.MinRoomSize + rng.Intn(Min(opts.MaxRoomSize, leaf.H-2)-opts.MinRoomSize+1)
+		r := Rect{w, h, leaf.X + 1 + rng.Intn(leaf.W-w-1), leaf.Y + 1 + rng.Intn(leaf.H-h-1)}
+		m.fillRect(r, tiles.Floor)
+		d.addRoom(r)
+		return []int{len(d.Rooms) - 1}
+	}
+
+	roomsA := m.bspSplit(d, a, opts, tiles, rng)
+	roomsB := m.bspSplit(d, b, opts, tiles, rng)
+	if len(roomsA) > 0 && len(roomsB) > 0 {
+		ra, rb := closestRooms(d, roomsA, roomsB)
+		m.digCorridor(d, d.roomCentre(ra), d.roomCentre(rb), tiles, rng)
+	}
+
+	return append(roomsA, roomsB...)
+}
+
+//Finds the pair of rooms, one from each list, with the closest centres.
+func closestRooms(d *Dungeon, as, bs []int) (ra, rb int) {
+	best := -1
+	for _, a := range as {
+		for _, b := range bs {
+			ca, cb := d.roomCentre(a), d.roomCentre(b)
+			if dist := Distance(ca.X, ca.Y, cb.X, cb.Y); best == -1 || dist < best {
+				best, ra, rb = dist, a, b
+			}
+		}
+	}
+	return
+}
+
+//Options for GenerateCave(). Zero values get sensible defaults.
+type CaveOptions struct {
+	FillPercent   int  //percentage of the map that starts as wall. default 45
+	Iterations    int  //smoothing passes. default 5
+	MinRegionSize int  //caves smaller than this are filled in. default 16
+	Connect       bool //tunnel between all the caves. if false, only the biggest cave is kept.
+}
+
+//Generates organic caves with cellular automata: the map starts as random noise, then is smoothed
+//repeatedly so that tiles become walls if most of their neighbours are walls. The resulting caves are
+//returned as the dungeon's "rooms" (their bounding rects, so they can overlap), and the graph records
+//the tunnels dug between them. There are no doors. Returns nil if no caves big enough were made.
+func (m *TileMap) GenerateCave(seed int64, tiles DungeonTiles, opts CaveOptions) *Dungeon {
+	rng := rand.New(rand.NewSource(seed))
+	d := newDungeon(seed)
+	if opts.FillPercent <= 0 {
+		opts.FillPercent = 45
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = 5
+	}
+	if opts.MinRegionSize <= 0 {
+		opts.MinRegionSize = 16
+	}
+
+	wall := make([]bool, len(m.Tiles))
+	for i := range wall {
+		x, y := i%m.Width, i/m.Width
+		wall[i] = x == 0 || y == 0 || x == m.Width-1 || y == m.Height-1 || rng.Intn(100) < opts.FillPercent
+	}
+
+	next := make([]bool, len(wall))
+	for n := 0; n < opts.Iterations; n++ {
+		for i := range wall {
+			x, y := i%m.Width, i/m.Width
+			walls := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if !CheckBounds(x+dx, y+dy, m.Width, m.Height) || wall[x+dx+(y+dy)*m.Width] {
+						walls++
+					}
+				}
+			}
+			next[i] = walls >= 5 || x == 0 || y == 0 || x == m.Width-1 || y == m.Height-1
+		}
+		wall, next = next, wall
+	}
+
+	for i := range wall {
+		if wall[i] {
+			m.ChangeTileType(i%m.Width, i/m.Width, tiles.Wall)
+		} else {
+			m.ChangeTileType(i%m.Width, i/m.Width, tiles.Floor)
+		}
+	}
+
+	//find the caves, biggest first. small ones get filled in.
+	regions := m.floodRegions(func(x, y int) bool { return m.GetTileType(x, y) == tiles.Floor })
+	sort.SliceStable(regions, func(i, j int) bool { return len(regions[i]) > len(regions[j]) })
+	keep := trimRegions(regions, opts.MinRegionSize, opts.Connect)
+	for _, r := range regions[len(keep):] {
+		for _, c := range r {
+			m.ChangeTileType(c.X, c.Y, tiles.Wall)
+		}
+	}
+	regions = keep
+
+	if len(regions) == 0 {
+		return nil
+	}
+
+	regionOf := make([]int, len(m.Tiles))
+	for i := range regionOf {
+		regionOf[i] = -1
+	}
+	for i, r := range regions {
+		d.addRoom(boundingRect(r))
+		for _, c := range r {
+			regionOf[c.X+c.Y*m.Width] = i
+		}
+	}
+
+	//tunnel from each cave to the nearest cave already connected to the biggest one
+	connected := make([]bool, len(regions))
+	connected[0] = true
+	for i := 1; i < len(regions); i++ {
+		tunnel, target := m.nearestRegion(regions[i], func(c Coord) bool {
+			r := regionOf[c.X+c.Y*m.Width]
+			return r >= 0 && r != i && connected[r]
+		})
+		for _, c := range tunnel {
+			m.ChangeTileType(c.X, c.Y, tiles.Floor)
+			d.connect(i, regionOf[c.X+c.Y*m.Width]) //tunnels can pass through other caves
+		}
+		if target != nil {
+			d.connect(i, regionOf[target.X+target.Y*m.Width])
+		}
+		connected[i] = true
+	}
+
+	d.Start = closestTo(regions[0], d.roomCentre(0))
+	return d
+}
+
+//Drops regions that are too small, and all but the biggest if they aren't going to be connected.
+//regions must be sorted biggest first.
+func trimRegions(regions [][]Coord, minSize int, keepAll bool) [][]Coord {
+	n := 0
+	for n < len(regions) && len(regions[n]) >= minSize && (keepAll || n == 0) {
+		n++
+	}
+	return regions[:n]
+}
+
+//Finds the shortest tunnel (4-way, through anything) from region to a tile satisfying target. Returns
+//the tiles that need digging and the target tile reached, or nil if no target could be found.
+func (m *TileMap) nearestRegion(region []Coord, target func(c Coord) bool) (tunnel []Coord, found *Coord) {
+	from := make([]int, len(m.Tiles))
+	for i := range from {
+		from[i] = -2
+	}
+
+	queue := make([]int, 0, len(region))
+	for _, c := range region {
+		from[c.X+c.Y*m.Width] = -1
+		queue = append(queue, c.X+c.Y*m.Width)
+	}
+
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		c := Coord{i % m.Width, i / m.Width}
+		if from[i] != -1 && target(c) {
+			for p := from[i]; p >= 0 && from[p] != -1; p = from[p] {
+				tunnel = append(tunnel, Coord{p % m.Width, p / m.Width})
+			}
+			return tunnel, &c
+		}
+
+		for _, dir := range dirs4 {
+			nx, ny := c.X+dir.X, c.Y+dir.Y
+			//stay off the map edge so caves stay enclosed
+			if nx <= 0 || ny <= 0 || nx >= m.Width-1 || ny >= m.Height-1 || from[nx+ny*m.Width] != -2 {
+				continue
+			}
+			from[nx+ny*m.Width] = i
+			queue = append(queue, nx+ny*m.Width)
+		}
+	}
+
+	return nil, nil
+}
+
+//Splits the tiles satisfying test into 4-way connected regions, in scan order.
+func (m *TileMap) floodRegions(test func(x, y int) bool) (regions [][]Coord) {
+	seen := make([]bool, len(m.Tiles))
+	for i := range m.Tiles {
+		x, y := i%m.Width, i/m.Width
+		if seen[i] || !test(x, y) {
+			continue
+		}
+
+		var region []Coord
+		FloodFill(Coord{x, y}, func(x, y int) bool {
+			return CheckBounds(x, y, m.Width, m.Height) && !seen[x+y*m.Width] && test(x, y)
+		}, func(x, y int) {
+			seen[x+y*m.Width] = true
+			region = append(region, Coord{x, y})
+		})
+		regions = append(regions, region)
+	}
+
+	return
+}
+
+func boundingRect(cs []Coord) Rect {
+	minX, minY, maxX, maxY := cs[0].X, cs[0].Y, cs[0].X, cs[0].Y
+	for _, c := range cs {
+		minX, minY = Min(minX, c.X), Min(minY, c.Y)
+		maxX, maxY = Max(maxX, c.X), Max(maxY, c.Y)
+	}
+	return Rect{maxX - minX + 1, maxY - minY + 1, minX, minY}
+}
+
+//Returns the coord in cs closest to target.
+func closestTo(cs []Coord, target Coord) (best Coord) {
+	bestDist := -1
+	for _, c := range cs {
+		if d := Distance(c.X, c.Y, target.X, target.Y); bestDist == -1 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return
+}
+
+//Options for GenerateDrunkardsWalk(). Zero values get sensible defaults.
+type WalkOptions struct {
+	Coverage int //percentage of the map to dig out. default 40
+	Walkers  int //number of drunkards. each one after the first starts somewhere already dug. default 1
+}
+
+//Generates winding tunnels by sending drunkards stumbling around the map from the centre, digging as
+//they go. Everything dug is connected. There are no rooms. Returns nil if the map is too small to dig in.
+func (m *TileMap) GenerateDrunkardsWalk(seed int64, tiles DungeonTiles, opts WalkOptions) *Dungeon {
+	rng := rand.New(rand.NewSource(seed))
+	d := newDungeon(seed)
+	if opts.Coverage <= 0 {
+		opts.Coverage = 40
+	}
+	if opts.Walkers <= 0 {
+		opts.Walkers = 1
+	}
+
+	m.fillTiles(tiles.Wall)
+	if m.Width < 3 || m.Height < 3 {
+		return nil
+	}
+
+	d.Start = Coord{m.Width / 2, m.Height / 2}
+	m.ChangeTileType(d.Start.X, d.Start.Y, tiles.Floor)
+	dug := []Coord{d.Start}
+	target := Max((m.Width-2)*(m.Height-2)*Min(opts.Coverage, 100)/100, 1)
+	stepsPerWalker := Max(target*20/opts.Walkers, 1) //so a boxed-in walker can't go forever
+
+	for w := 0; w < opts.Walkers && len(dug) < target; w++ {
+		pos := dug[rng.Intn(len(dug))]
+		if w == 0 {
+			pos = d.Start
+		}
+
+		for step := 0; step < stepsPerWalker && len(dug) < target; step++ {
+			dir := dirs4[rng.Intn(4)]
+			pos.X = Clamp(pos.X+dir.X, 1, m.Width-2)
+			pos.Y = Clamp(pos.Y+dir.Y, 1, m.Height-2)
+			if m.GetTileType(pos.X, pos.Y) != tiles.Floor {
+				m.ChangeTileType(pos.X, pos.Y, tiles.Floor)
+				dug = append(dug, pos)
+			}
+		}
+	}
+
+	return d
+}
+
+//Options for GeneratePrefabRooms(). Zero values get sensible defaults.
+type RoomOptions struct {
+	MaxRooms int //default 20
+	Attempts int //how many times to try placing a room before giving up. default 200
+}
+
+//Scatters prefab rooms randomly over the map without overlapping, then joins each to the nearest room
+//placed before it with a corridor. Corridors knock through prefab walls where they hit them, making
+//doors. Everything is connected. Returns nil if no prefab fits on the map.
+func (m *TileMap) GeneratePrefabRooms(seed int64, tiles DungeonTiles, prefabs []*Prefab, opts RoomOptions) *Dungeon {
+	rng := rand.New(rand.NewSource(seed))
+	d := newDungeon(seed)
+	if opts.MaxRooms <= 0 {
+		opts.MaxRooms = 20
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 200
+	}
+
+	m.fillTiles(tiles.Wall)
+	if len(prefabs) == 0 {
+		return nil
+	}
+
+	for n := 0; n < opts.Attempts && len(d.Rooms) < opts.MaxRooms; n++ {
+		p := prefabs[rng.Intn(len(prefabs))]
+		if p.Width > m.Width-2 || p.Height > m.Height-2 {
+			continue
+		}
+
+		r := Rect{p.Width, p.Height, 1 + rng.Intn(m.Width-p.Width-1), 1 + rng.Intn(m.Height-p.Height-1)}
+		if d.overlaps(Rect{r.W + 2, r.H + 2, r.X - 1, r.Y - 1}) {
+			continue
+		}
+
+		m.Stamp(p, r.X, r.Y)
+		d.addRoom(r)
+	}
+
+	//connect after placing everything, so rooms don't get stamped over corridors
+	for i := 1; i < len(d.Rooms); i++ {
+		_, other := closestRooms(d, []int{i}, seq(i))
+		m.digCorridor(d, d.roomCentre(i), d.roomCentre(other), tiles, rng)
+	}
+
+	if !d.placeStart(m, tiles) {
+		return nil
+	}
+
+	return d
+}
+
+//Reports whether r overlaps any room in the dungeon.
+func (d *Dungeon) overlaps(r Rect) bool {
+	for _, room := range d.Rooms {
+		if r.X < room.X+room.W && room.X < r.X+r.W && r.Y < room.Y+room.H && room.Y < r.Y+r.H {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the ints 0 to n-1.
+func seq(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
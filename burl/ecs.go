@@ -0,0 +1,315 @@
+package burl
+
+import (
+	"reflect"
+	"sort"
+)
+
+//An optional entity-component-system, for games that have outgrown building entities by embedding
+//structs. Entities are just IDs, components are plain data attached to them, and systems are run every
+//update to do things to all the entities with a particular set of components.
+//
+//Component types are registered with RegisterComponent(), much like custom events. Components are
+//stored untyped, as interface{}, so nothing checks that a component is the type you expect: a wrong type
+//assertion on GetComponent() panics, and the comma-ok form quietly gets nothing. Components can be any
+//value, but use pointers if you want to change them in place:
+//
+//	var COMP_HEALTH = burl.RegisterComponent()
+//	ecs.AddComponent(id, COMP_HEALTH, &Health{10})
+//	ecs.GetComponent(id, COMP_HEALTH).(*Health).HP -= 3
+//
+//ECS entities can go on TileMaps via Bridge(), which wraps them up as an Entity.
+
+type EntityID uint32
+
+type ComponentType int
+
+var componentNum int
+
+//Registers a new component type.
+func RegisterComponent() ComponentType {
+	componentNum++
+	return ComponentType(componentNum)
+}
+
+//Components used by the TileMap bridge. See Bridge().
+var (
+	COMP_POSITION = RegisterComponent() //*Coord
+	COMP_VISUALS  = RegisterComponent() //*Visuals
+	COMP_LIGHT    = RegisterComponent() //*EntityLight
+	COMP_BLOCKING = RegisterComponent() //anything, presence is what matters. blocks movement like a normal Entity.
+)
+
+//System is run on every update of the ECS it's added to.
+type System interface {
+	Update(ecs *ECS)
+}
+
+//SystemFunc lets a plain function be used as a system. See RemoveSystem() though.
+type SystemFunc func(ecs *ECS)
+
+func (f SystemFunc) Update(ecs *ECS) {
+	f(ecs)
+}
+
+type ECS struct {
+	nextID  EntityID
+	alive   map[EntityID]bool
+	stores  map[ComponentType]*componentStore
+	systems []System
+	bridges map[EntityID]*ECSEntity
+}
+
+//Components of one type, by entity. ids is kept sorted so queries come out in a stable order.
+type componentStore struct {
+	data map[EntityID]interface{}
+	ids  []EntityID
+}
+
+func NewECS() *ECS {
+	return &ECS{
+		alive:   make(map[EntityID]bool),
+		stores:  make(map[ComponentType]*componentStore),
+		bridges: make(map[EntityID]*ECSEntity),
+	}
+}
+
+//Creates a new entity with no components. IDs are never reused, and 0 is never an entity.
+func (ecs *ECS) CreateEntity() EntityID {
+	ecs.nextID++
+	ecs.alive[ecs.nextID] = true
+	return ecs.nextID
+}
+
+//Destroys an entity and all its components. If it's been bridged onto a map, remove it from the map first.
+func (ecs *ECS) DestroyEntity(id EntityID) {
+	if !ecs.alive[id] {
+		return
+	}
+	for ct := range ecs.stores {
+		ecs.RemoveComponent(id, ct)
+	}
+	delete(ecs.alive, id)
+	delete(ecs.bridges, id)
+}
+
+//Reports whether the entity exists.
+func (ecs *ECS) Alive(id EntityID) bool {
+	return ecs.alive[id]
+}
+
+//Returns the number of living entities.
+func (ecs *ECS) Count() int {
+	return len(ecs.alive)
+}
+
+//Attaches component c of type ct to the entity, replacing any it already had.
+func (ecs *ECS) AddComponent(id EntityID, ct ComponentType, c interface{}) {
+	if !ecs.alive[id] {
+		LogError("Tried to add component to nonexistent entity ", id)
+		return
+	}
+
+	s := ecs.stores[ct]
+	if s == nil {
+		s = &componentStore{data: make(map[EntityID]interface{})}
+		ecs.stores[ct] = s
+	}
+
+	if _, ok := s.data[id]; !ok {
+		i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+		s.ids = append(s.ids, 0)
+		copy(s.ids[i+1:], s.ids[i:])
+		s.ids[i] = id
+	}
+	s.data[id] = c
+}
+
+//Returns the entity's component of type ct, or nil if it doesn't have one.
+func (ecs *ECS) GetComponent(id EntityID, ct ComponentType) interface{} {
+	if s := ecs.stores[ct]; s != nil {
+		return s.data[id]
+	}
+	return nil
+}
+
+//Reports whether the entity has a component of type ct.
+func (ecs *ECS) HasComponent(id EntityID, ct ComponentType) bool {
+	if s := ecs.stores[ct]; s != nil {
+		_, ok := s.data[id]
+		return ok
+	}
+	return false
+}
+
+//Removes the entity's component of type ct, if it has one.
+func (ecs *ECS) RemoveComponent(id EntityID, ct ComponentType) {
+	s := ecs.stores[ct]
+	if s == nil {
+		return
+	}
+	if _, ok := s.data[id]; !ok {
+		return
+	}
+
+	delete(s.data, id)
+	i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+	s.ids = append(s.ids[:i], s.ids[i+1:]...)
+}
+
+//Returns the entities that have all of the given component types, in order of creation. With no types,
+//returns every entity.
+func (ecs *ECS) Query(cts ...ComponentType) (ids []EntityID) {
+	if len(cts) == 0 {
+		for id := range ecs.alive {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return
+	}
+
+	//go through the smallest store and check the others
+	var smallest *componentStore
+	for _, ct := range cts {
+		s := ecs.stores[ct]
+		if s == nil {
+			return nil
+		}
+		if smallest == nil || len(s.ids) < len(smallest.ids) {
+			smallest = s
+		}
+	}
+
+	for _, id := range smallest.ids {
+		has := true
+		for _, ct := range cts {
+			if _, ok := ecs.stores[ct].data[id]; !ok {
+				has = false
+				break
+			}
+		}
+		if has {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+//Adds a system. Systems run in the order they're added.
+func (ecs *ECS) AddSystem(s System) {
+	ecs.systems = append(ecs.systems, s)
+}
+
+//Removes a system. Functions can't be compared in Go, so SystemFuncs can't be removed: use your own
+//type if you need to.
+func (ecs *ECS) RemoveSystem(s System) {
+	if !reflect.TypeOf(s).Comparable() {
+		LogError("Can't remove a system of uncomparable type ", reflect.TypeOf(s).String())
+		return
+	}
+	for i := range ecs.systems {
+		if ecs.systems[i] == s {
+			ecs.systems = append(ecs.systems[:i], ecs.systems[i+1:]...)
+			return
+		}
+	}
+}
+
+//Runs all the systems once. Attached ECSs are updated automatically by the game loop, see AttachECS().
+func (ecs *ECS) Update() {
+	for _, s := range ecs.systems {
+		s.Update(ecs)
+	}
+}
+
+//ECSs updated by the game loop.
+var attachedECS []*ECS
+
+//Has the game loop run the ECS's systems every update, just after the state's Update(). Systems don't
+//run while a dialog is open or a state transition is happening, same as the state. Turn-based games
+//usually want to call Update() themselves instead, when a turn passes.
+func AttachECS(ecs *ECS) {
+	DetachECS(ecs)
+	attachedECS = append(attachedECS, ecs)
+}
+
+func DetachECS(ecs *ECS) {
+	for i := range attachedECS {
+		if attachedECS[i] == ecs {
+			attachedECS = append(attachedECS[:i], attachedECS[i+1:]...)
+			return
+		}
+	}
+}
+
+func updateECS() {
+	for _, ecs := range attachedECS {
+		ecs.Update()
+	}
+}
+
+//ECSEntity wraps an ECS entity so it can be put on a TileMap and drawn by views like any other Entity.
+//Its position, visuals and light come from its COMP_POSITION, COMP_VISUALS and COMP_LIGHT components,
+//and it blocks if it has a COMP_BLOCKING component.
+//
+//Maps don't know when components change. Maps light and place entities when they're added and expect
+//the same when they're removed, so don't add or remove COMP_BLOCKING or change COMP_LIGHT while the
+//entity is on a map: take it off, change it, and put it back. Like any Entity, moving it with
+//TileMap.MoveEntity() doesn't change its position, so call Move() too to keep COMP_POSITION in step.
+//COMP_VISUALS can change whenever you like, it's read every time the entity is drawn.
+type ECSEntity struct {
+	ecs *ECS
+	ID  EntityID
+}
+
+//Returns the bridge for an entity, for putting it on a map. The same entity always gets the same bridge,
+//so maps can find it again. Put it on the map at its position:
+//
+//	pos := ecs.GetComponent(id, burl.COMP_POSITION).(*burl.Coord)
+//	tileMap.AddEntity(pos.X, pos.Y, ecs.Bridge(id))
+func (ecs *ECS) Bridge(id EntityID) *ECSEntity {
+	if !ecs.alive[id] {
+		return nil
+	}
+	b := ecs.bridges[id]
+	if b == nil {
+		b = &ECSEntity{ecs, id}
+		ecs.bridges[id] = b
+	}
+	return b
+}
+
+func (e *ECSEntity) position() *Coord {
+	if p, ok := e.ecs.GetComponent(e.ID, COMP_POSITION).(*Coord); ok {
+		return p
+	}
+	p := new(Coord)
+	e.ecs.AddComponent(e.ID, COMP_POSITION, p)
+	return p
+}
+
+func (e *ECSEntity) Move(dx, dy int) {
+	e.position().Move(dx, dy)
+}
+
+func (e *ECSEntity) MoveTo(x, y int) {
+	e.position().MoveTo(x, y)
+}
+
+func (e *ECSEntity) GetLight() EntityLight {
+	if l, ok := e.ecs.GetComponent(e.ID, COMP_LIGHT).(*EntityLight); ok {
+		return *l
+	}
+	return EntityLight{}
+}
+
+func (e *ECSEntity) IsBlocking() bool {
+	return e.ecs.HasComponent(e.ID, COMP_BLOCKING)
+}
+
+func (e *ECSEntity) GetVisuals() Visuals {
+	if v, ok := e.ecs.GetComponent(e.ID, COMP_VISUALS).(*Visuals); ok {
+		return *v
+	}
+	return Visuals{}
+}
@@ -9,18 +9,38 @@ type Entity interface {
 	Drawable
 }
 
+//Optional interface for entities that can share their tile. Blocking entities occupy their tile, only
+//one can be on a tile at a time. Entities that don't implement Blocker are blocking.
+type Blocker interface {
+	IsBlocking() bool
+}
+
+//Reports whether e occupies its tile. See Blocker.
+func isBlocking(e Entity) bool {
+	if b, ok := e.(Blocker); ok {
+		return b.IsBlocking()
+	}
+	return true
+}
+
+//Anything that gives off light. Entities always do (even if it's strength 0), items can if they want.
+type LightEmitter interface {
+	GetLight() EntityLight
+}
+
 //Basic entity definition. Entities position and visual information. Anything that occupies space on
 //a TileMap is an entity. Players and enemies, furniture, doors, whatever. More specific things can be
 //added to this class to give all possible entites these features.
 type EntityPrototype struct {
 	Visuals //Visual information for rendering to the TileMap
 	
-	X, Y  int     //Position on a TileMap
-	Light EntityLight
+	X, Y        int     //Position on a TileMap
+	Light       EntityLight
+	NonBlocking bool //if true, other entities can share the tile with this one.
 }
 
 func NewBurlEntity(x, y int, v Visuals) *EntityPrototype {
-	return &EntityPrototype{v, x, y, EntityLight{}}
+	return &EntityPrototype{Visuals: v, X: x, Y: y}
 }
 
 func (e *EntityPrototype) Move(dx, dy int) {
@@ -37,10 +57,20 @@ func (e EntityPrototype) GetLight() EntityLight {
 	return e.Light
 }
 
+func (e EntityPrototype) IsBlocking() bool {
+	return !e.NonBlocking
+}
+
 //Struct defining the light generated by an entity. All dynamic light on the TileMap must be an entity,
 //even if it is invisible.
 type EntityLight struct {
 	Strength int
-	Colour   uint32
-	//Flicker bool //maybe the light can flicker? TODO: think harder about this.
+	Colour   uint32       //COL_NONE for plain white light.
+	Falloff  LightFalloff //how the light dims towards the edge of its radius
+
+	//Animated and directional lights are only supported by maps with a LightManager.
+	Mode      LightMode //steady, flickering or pulsing
+	Period    int       //in ticks. how fast the light flickers or pulses.
+	Direction int       //in degrees, clockwise from east (+x). cone lights point this way.
+	Arc       int       //width of the cone in degrees. 0 for a light that shines all around.
 }
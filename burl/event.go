@@ -11,6 +11,11 @@ const (
 	EV_ANIMATION_DONE
 	EV_BUTTON_PRESS
 	EV_LIST_CYCLE
+	EV_TRANSITION_DONE //state transition has finished, new state can start handling input
+	EV_TILEDATA_RELOADED //a tile data file was hot-reloaded. message is the path to the file.
+	EV_LEVEL_CHANGED     //the active level of a LevelSet changed. message is the new level's ID.
+	EV_STAT_MODIFIER_EXPIRED //a stat modifier ran out. message is the modifier's source.
+	EV_STATUS_EXPIRED        //a status effect ran out. message is the status's name.
 	EV_MAX_EVENTS
 )
 
@@ -0,0 +1,293 @@
+package burl
+
+//FOV is anything that can compute a field of view on a TileMap. Implementations all follow the same
+//contract as TileMap.ShadowCast(): fn is called once on each tile within radius that can be seen from
+//(x, y), including (x, y) itself, with d being the squared distance from (x, y).
+type FOV interface {
+	Cast(m *TileMap, x, y, radius int, fn Cast)
+}
+
+//ShadowCaster is the classic burl shadowcaster (see TileMap.ShadowCast()). Fast, but not symmetric:
+//sometimes A can see B but B can't see A.
+type ShadowCaster struct{}
+
+func (ShadowCaster) Cast(m *TileMap, x, y, radius int, fn Cast) {
+	m.ShadowCast(x, y, radius, fn)
+}
+
+//SymmetricShadowCaster implements Albert Ford's symmetric shadowcasting. Floor tiles are always
+//seen symmetrically (if A can see B, B can see A), walls are lit if any part of them is visible, and
+//there are no artefacts peeking around pillars. Like the original algorithm, light can squeeze through
+//a diagonal gap between two walls, so what's on the other side can be glimpsed. Slopes are computed
+//exactly with integer fractions.
+type SymmetricShadowCaster struct{}
+
+func (SymmetricShadowCaster) Cast(m *TileMap, x, y, radius int, fn Cast) {
+	if radius <= 0 || !CheckBounds(x, y, m.Width, m.Height) {
+		return
+	}
+
+	fn(m, x, y, 0, radius)
+	diagonals := make(map[int]bool) //quadrants overlap on the diagonals, so track those to avoid doubling up
+	for q := 0; q < 4; q++ {
+		sc := symmetricScan{m, x, y, radius, q, fn, diagonals}
+		sc.scan(1, fraction{-1, 1}, fraction{1, 1})
+	}
+}
+
+//fraction for exact slope computations. den is always positive.
+type fraction struct {
+	num, den int
+}
+
+type symmetricScan struct {
+	m         *TileMap
+	ox, oy    int
+	radius    int
+	quadrant  int //0 = north, 1 = east, 2 = south, 3 = west
+	fn        Cast
+	diagonals map[int]bool
+}
+
+//converts (depth, col) coordinates in the current quadrant to map coordinates.
+func (sc symmetricScan) transform(depth, col int) (int, int) {
+	switch sc.quadrant {
+	case 0:
+		return sc.ox + col, sc.oy - depth
+	case 1:
+		return sc.ox + depth, sc.oy + col
+	case 2:
+		return sc.ox + col, sc.oy + depth
+	default:
+		return sc.ox - depth, sc.oy + col
+	}
+}
+
+//tiles out of bounds are treated as walls, but are never revealed.
+func (sc symmetricScan) isWall(depth, col int) bool {
+	x, y := sc.transform(depth, col)
+	return !CheckBounds(x, y, sc.m.Width, sc.m.Height) || !sc.m.Tiles[x+y*sc.m.Width].Transparent()
+}
+
+func (sc symmetricScan) reveal(depth, col int) {
+	x, y := sc.transform(depth, col)
+	if !CheckBounds(x, y, sc.m.Width, sc.m.Height) {
+		return
+	}
+
+	if col == depth || col == -depth {
+		if sc.diagonals[x+y*sc.m.Width] {
+			return
+		}
+		sc.diagonals[x+y*sc.m.Width] = true
+	}
+
+	if d := Distance(sc.ox, sc.oy, x, y); d < sc.radius*sc.radius {
+		sc.fn(sc.m, x, y, d, sc.radius)
+	}
+}
+
+func (sc symmetricScan) scan(depth int, start, end fraction) {
+	if depth > sc.radius {
+		return
+	}
+
+	minCol := floorDiv(2*depth*start.num+start.den, 2*start.den) //round ties up
+	maxCol := -floorDiv(-(2*depth*end.num - end.den), 2*end.den) //round ties down
+
+	prevWall, first := false, true
+	for col := minCol; col <= maxCol; col++ {
+		wall := sc.isWall(depth, col)
+
+		//tiles are revealed if they're walls, or their centers are within the slopes
+		symmetric := col*start.den >= depth*start.num && col*end.den <= depth*end.num
+		if wall || symmetric {
+			sc.reveal(depth, col)
+		}
+
+		if !first {
+			if prevWall && !wall {
+				start = fraction{2*col - 1, 2 * depth}
+			}
+			if !prevWall && wall {
+				sc.scan(depth+1, start, fraction{2*col - 1, 2 * depth})
+			}
+		}
+
+		prevWall, first = wall, false
+	}
+
+	if !first && !prevWall {
+		sc.scan(depth+1, start, end)
+	}
+}
+
+//integer division rounding towards negative infinity.
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+//PermissiveFOV implements precise permissive field of view: a tile is visible if there is any
+//unobstructed line from anywhere in the origin tile to anywhere in the target tile. Very generous,
+//and symmetric, but lets you see around corners more than the shadowcasters do, and through diagonal
+//gaps between walls.
+type PermissiveFOV struct{}
+
+func (PermissiveFOV) Cast(m *TileMap, x, y, radius int, fn Cast) {
+	if radius <= 0 || !CheckBounds(x, y, m.Width, m.Height) {
+		return
+	}
+
+	pc := permissiveCast{m, x, y, radius, fn, map[int]bool{x + y*m.Width: true}}
+	fn(m, x, y, 0, radius)
+
+	minX, maxX := Min(x, radius), Min(m.Width-x-1, radius)
+	minY, maxY := Min(y, radius), Min(m.Height-y-1, radius)
+
+	pc.checkQuadrant(1, 1, maxX, maxY)
+	pc.checkQuadrant(1, -1, maxX, minY)
+	pc.checkQuadrant(-1, -1, minX, minY)
+	pc.checkQuadrant(-1, 1, minX, maxY)
+}
+
+type permissiveCast struct {
+	m       *TileMap
+	ox, oy  int
+	radius  int
+	fn      Cast
+	visited map[int]bool //quadrants overlap along the axes
+}
+
+//lines and bumps for the permissive algorithm. coordinates are tile corners in quadrant space.
+type permLine struct {
+	xi, yi, xf, yf int
+}
+
+func (l permLine) relativeSlope(x, y int) int {
+	return (l.yf-l.yi)*(l.xf-x) - (l.xf-l.xi)*(l.yf-y)
+}
+
+func (l permLine) isBelow(x, y int) bool           { return l.relativeSlope(x, y) > 0 }
+func (l permLine) isBelowOrContains(x, y int) bool { return l.relativeSlope(x, y) >= 0 }
+func (l permLine) isAbove(x, y int) bool           { return l.relativeSlope(x, y) < 0 }
+func (l permLine) isAboveOrContains(x, y int) bool { return l.relativeSlope(x, y) <= 0 }
+func (l permLine) isCollinear(x, y int) bool       { return l.relativeSlope(x, y) == 0 }
+
+func (l permLine) isLineCollinear(l2 permLine) bool {
+	return l.isCollinear(l2.xi, l2.yi) && l.isCollinear(l2.xf, l2.yf)
+}
+
+type permBump struct {
+	x, y   int
+	parent *permBump
+}
+
+type permView struct {
+	shallowLine, steepLine permLine
+	shallowBump, steepBump *permBump
+}
+
+func (pc *permissiveCast) checkQuadrant(dx, dy, extentX, extentY int) {
+	views := []*permView{{shallowLine: permLine{0, 1, extentX, 0}, steepLine: permLine{1, 0, 0, extentY}}}
+
+	for i := 1; i <= extentX+extentY && len(views) > 0; i++ {
+		for j := Max(0, i-extentX); j <= Min(i, extentY) && len(views) > 0; j++ {
+			views = pc.visitCoord(i-j, j, dx, dy, views)
+		}
+	}
+}
+
+func (pc *permissiveCast) visitCoord(x, y, dx, dy int, views []*permView) []*permView {
+	topLeftX, topLeftY := x, y+1
+	bottomRightX, bottomRightY := x+1, y
+
+	//find the view this tile is in, if any
+	i := 0
+	for i < len(views) && views[i].steepLine.isBelowOrContains(bottomRightX, bottomRightY) {
+		i++
+	}
+	if i == len(views) || views[i].shallowLine.isAboveOrContains(topLeftX, topLeftY) {
+		return views
+	}
+
+	mx, my := pc.ox+x*dx, pc.oy+y*dy
+	if !pc.visited[mx+my*pc.m.Width] {
+		pc.visited[mx+my*pc.m.Width] = true
+		if d := Distance(pc.ox, pc.oy, mx, my); d < pc.radius*pc.radius {
+			pc.fn(pc.m, mx, my, d, pc.radius)
+		}
+	}
+
+	if pc.m.Tiles[mx+my*pc.m.Width].Transparent() {
+		return views
+	}
+
+	//tile is blocking, so narrow or split the view
+	v := views[i]
+	aboveShallow := v.shallowLine.isAbove(bottomRightX, bottomRightY)
+	belowSteep := v.steepLine.isBelow(topLeftX, topLeftY)
+
+	switch {
+	case aboveShallow && belowSteep:
+		//tile blocks the whole view
+		views = append(views[:i], views[i+1:]...)
+	case aboveShallow:
+		addShallowBump(topLeftX, topLeftY, v)
+		views = checkView(views, i)
+	case belowSteep:
+		addSteepBump(bottomRightX, bottomRightY, v)
+		views = checkView(views, i)
+	default:
+		//tile is in the middle of the view, split it in two
+		shallow := &permView{v.shallowLine, v.steepLine, v.shallowBump, v.steepBump}
+		views = append(views[:i], append([]*permView{shallow}, views[i:]...)...)
+		steepIndex := i + 1
+
+		addSteepBump(bottomRightX, bottomRightY, views[i])
+		n := len(views)
+		views = checkView(views, i)
+		if len(views) != n {
+			steepIndex--
+		}
+
+		addShallowBump(topLeftX, topLeftY, views[steepIndex])
+		views = checkView(views, steepIndex)
+	}
+
+	return views
+}
+
+func addShallowBump(x, y int, v *permView) {
+	v.shallowLine.xf, v.shallowLine.yf = x, y
+	v.shallowBump = &permBump{x, y, v.shallowBump}
+
+	for b := v.steepBump; b != nil; b = b.parent {
+		if v.shallowLine.isAbove(b.x, b.y) {
+			v.shallowLine.xi, v.shallowLine.yi = b.x, b.y
+		}
+	}
+}
+
+func addSteepBump(x, y int, v *permView) {
+	v.steepLine.xf, v.steepLine.yf = x, y
+	v.steepBump = &permBump{x, y, v.steepBump}
+
+	for b := v.shallowBump; b != nil; b = b.parent {
+		if v.steepLine.isBelow(b.x, b.y) {
+			v.steepLine.xi, v.steepLine.yi = b.x, b.y
+		}
+	}
+}
+
+//Removes the view at index i if it has collapsed into a line along one of the axes.
+func checkView(views []*permView, i int) []*permView {
+	shallow, steep := views[i].shallowLine, views[i].steepLine
+	if shallow.isLineCollinear(steep) && (shallow.isCollinear(0, 1) || shallow.isCollinear(1, 0)) {
+		return append(views[:i], views[i+1:]...)
+	}
+	return views
+}
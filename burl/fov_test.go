@@ -0,0 +1,162 @@
+package burl
+
+import (
+	"math/rand"
+	"testing"
+)
+
+//Tile types for tests, loaded the first time they're needed.
+var testFloor, testWall int
+
+func loadTestTiles() {
+	if testFloor == 0 {
+		testFloor = LoadTileData("Test Floor", true, true, GLYPH_PERIOD, COL_WHITE)
+		testWall = LoadTileData("Test Wall", false, false, GLYPH_HASH, COL_WHITE)
+	}
+}
+
+//Generates a handful of maps for testing: random noise at a few densities, caves, and rooms and
+//corridors.
+func testMaps(seed int64) (maps []*TileMap) {
+	loadTestTiles()
+	r := rand.New(rand.NewSource(seed))
+	tiles := DungeonTiles{Floor: testFloor, Wall: testWall}
+
+	for _, density := range []float64{0.1, 0.25, 0.4} {
+		m := NewMap(24, 20)
+		for i := range m.Tiles {
+			if r.Float64() < density {
+				m.Tiles[i].TileType = testWall
+			} else {
+				m.Tiles[i].TileType = testFloor
+			}
+		}
+		maps = append(maps, m)
+	}
+
+	cave := NewMap(30, 24)
+	cave.GenerateCave(seed, tiles, CaveOptions{Connect: true})
+	rooms := NewMap(30, 24)
+	rooms.GenerateBSP(seed, tiles, BSPOptions{})
+	return append(maps, cave, rooms)
+}
+
+//Returns the tiles fov can see from (x, y), failing the test if any tile is reported twice.
+func fovSet(t *testing.T, fov FOV, m *TileMap, x, y, radius int) map[Coord]bool {
+	seen := make(map[Coord]bool)
+	fov.Cast(m, x, y, radius, func(m *TileMap, x, y, d, r int) {
+		if seen[Coord{x, y}] {
+			t.Fatalf("%T reported (%d, %d) twice", fov, x, y)
+		}
+		seen[Coord{x, y}] = true
+	})
+	return seen
+}
+
+func floorTiles(m *TileMap) (floors []Coord) {
+	for i, tile := range m.Tiles {
+		if IsTransparent(tile.TileType) {
+			floors = append(floors, Coord{i % m.Width, i / m.Width})
+		}
+	}
+	return
+}
+
+var symmetricFOVs = []FOV{SymmetricShadowCaster{}, PermissiveFOV{}}
+
+//If A can see B then B can see A, for every pair of floor tiles.
+func TestFOVSymmetry(t *testing.T) {
+	for seed := int64(0); seed < 4; seed++ {
+		for _, m := range testMaps(seed) {
+			floors := floorTiles(m)
+			for _, fov := range symmetricFOVs {
+				seen := make(map[Coord]map[Coord]bool)
+				for _, c := range floors {
+					seen[c] = fovSet(t, fov, m, c.X, c.Y, 50)
+				}
+
+				for _, a := range floors {
+					for b := range seen[a] {
+						if IsTransparent(m.GetTileType(b.X, b.Y)) && !seen[b][a] {
+							t.Fatalf("%T (seed %d): (%d, %d) sees (%d, %d) but not the other way around", fov, seed, a.X, a.Y, b.X, b.Y)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+//Light can't leak through walls: every floor tile seen has to be connected to the observer through floor
+//(8-way, since both FOVs let light through diagonal gaps between walls), and every wall seen has to be
+//next to that floor. Tiles directly behind a wall in line with the observer are never seen.
+func TestFOVNoPeeking(t *testing.T) {
+	for seed := int64(0); seed < 4; seed++ {
+		for _, m := range testMaps(seed) {
+			region := transparentRegions(m)
+			for _, fov := range symmetricFOVs {
+				for _, a := range floorTiles(m) {
+					for b := range fovSet(t, fov, m, a.X, a.Y, 50) {
+						if !touchesRegion(m, region, b, region[a]) {
+							t.Fatalf("%T (seed %d): (%d, %d) sees (%d, %d) through a wall", fov, seed, a.X, a.Y, b.X, b.Y)
+						}
+					}
+				}
+			}
+
+			for _, fov := range symmetricFOVs {
+				for _, a := range floorTiles(m) {
+					seen := fovSet(t, fov, m, a.X, a.Y, 50)
+					for _, d := range dirs4 {
+						if IsTransparent(m.GetTileType(a.X+d.X, a.Y+d.Y)) || !CheckBounds(a.X+d.X, a.Y+d.Y, m.Width, m.Height) {
+							continue
+						}
+						for k := 2; k < 5; k++ {
+							if behind := (Coord{a.X + d.X*k, a.Y + d.Y*k}); seen[behind] {
+								t.Fatalf("%T (seed %d): (%d, %d) sees (%d, %d) behind a wall", fov, seed, a.X, a.Y, behind.X, behind.Y)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+//Labels the 8-way connected areas of transparent tiles.
+func transparentRegions(m *TileMap) map[Coord]int {
+	region := make(map[Coord]int)
+	for _, start := range floorTiles(m) {
+		if region[start] != 0 {
+			continue
+		}
+		label := len(region) + 1 //anything unused will do
+		region[start] = label
+		for queue := []Coord{start}; len(queue) > 0; queue = queue[1:] {
+			for _, d := range dirs8 {
+				n := Coord{queue[0].X + d.X, queue[0].Y + d.Y}
+				if region[n] == 0 && CheckBounds(n.X, n.Y, m.Width, m.Height) && IsTransparent(m.GetTileType(n.X, n.Y)) {
+					region[n] = label
+					queue = append(queue, n)
+				}
+			}
+		}
+	}
+	return region
+}
+
+//Reports whether c is in region r, or is a wall next to it (diagonally counts, for the corners of rooms).
+func touchesRegion(m *TileMap, region map[Coord]int, c Coord, r int) bool {
+	if region[c] == r {
+		return true
+	}
+	if IsTransparent(m.GetTileType(c.X, c.Y)) {
+		return false
+	}
+	for _, d := range dirs8 {
+		if region[Coord{c.X + d.X, c.Y + d.Y}] == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package burl
+
+//Items are things that sit on a tile without occupying it: dropped weapons, loot, corpses, etc.
+//Any number of items can be stacked on a tile. Items that also implement LightEmitter will light
+//up the map while they're lying around.
+type Item interface {
+	Drawable
+}
+
+//Basic item definition. Build more interesting items around this.
+type ItemPrototype struct {
+	Visuals
+	Name string
+}
+
+func NewItem(name string, v Visuals) *ItemPrototype {
+	return &ItemPrototype{v, name}
+}
+
+func (i ItemPrototype) GetName() string {
+	return i.Name
+}
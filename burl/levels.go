@@ -0,0 +1,340 @@
+package burl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+//LevelSet holds all the levels of a game (dungeon depths, ship decks, the overworld and its caves) and
+//the connections between them. Each level is its own TileMap, so each keeps its own memory of what the
+//player has seen and its own lighting.
+//
+//Levels are generated the first time they're needed, and levels that haven't been used in a while are
+//unloaded to save memory. Unloaded levels are kept in the compact saved map format (see SaveMap()),
+//along with the entities, items and free-standing lights that were on them, and are brought back
+//exactly as they were when needed again.
+type LevelSet struct {
+	KeepLoaded int //how many levels to keep loaded, including the active one. default 3. checked on SetActive()
+
+	levels   map[string]*level
+	links    map[LevelPos]LevelPos
+	active   string
+	generate LevelGenerator
+	clock    int
+}
+
+//LevelPos is a position on a particular level.
+type LevelPos struct {
+	Level string
+	X, Y  int
+}
+
+//LevelGenerator makes the level with the given ID the first time it's needed. It can add links for the
+//new level to the set (stairs back up, say) before returning.
+type LevelGenerator func(ls *LevelSet, id string) (*TileMap, error)
+
+type level struct {
+	tileMap  *TileMap //nil when unloaded
+	lastUsed int
+
+	//everything needed to bring an unloaded level back
+	saved     []byte
+	things    []mapThing
+	lights    []LightSource //free-standing lights, if the level had a LightManager
+	hadLights bool
+}
+
+//An entity or item taken off a map that's been saved. The saved map formats don't store these, so
+//they're kept in memory until the map is loaded again.
+type mapThing struct {
+	x, y   int
+	entity Entity
+	item   Item
+}
+
+//Lists all the entities and items on the map, so they can be put back later with putThings().
+func (m *TileMap) takeThings() (things []mapThing) {
+	for i, t := range m.Tiles {
+		for _, e := range t.GetEntities() {
+			things = append(things, mapThing{x: i % m.Width, y: i / m.Width, entity: e})
+		}
+		for _, item := range t.items {
+			things = append(things, mapThing{x: i % m.Width, y: i / m.Width, item: item})
+		}
+	}
+	return
+}
+
+//Puts things straight back on their tiles. Saved maps already include any light they give off, so
+//going through AddEntity() would light them twice.
+func (m *TileMap) putThings(things []mapThing) {
+	m.index = nil //rebuilt when next needed
+	for _, t := range things {
+		tile := &m.Tiles[t.x+t.y*m.Width]
+		switch {
+		case t.item != nil:
+			tile.items = append(tile.items, t.item)
+		case isBlocking(t.entity):
+			tile.entity = t.entity
+		default:
+			tile.entities = append(tile.entities, t.entity)
+		}
+	}
+}
+
+//Creates an empty level set. gen is used to make levels that haven't been added with AddLevel(), it can
+//be nil if all levels are added by hand.
+func NewLevelSet(gen LevelGenerator) *LevelSet {
+	return &LevelSet{
+		KeepLoaded: 3,
+		levels:     make(map[string]*level),
+		links:      make(map[LevelPos]LevelPos),
+		generate:   gen,
+	}
+}
+
+//Adds a ready-made level to the set, replacing any level with the same ID. Like GetLevel(), the level
+//stays loaded at least until the next SetActive().
+func (ls *LevelSet) AddLevel(id string, m *TileMap) {
+	ls.clock++
+	ls.levels[id] = &level{tileMap: m, lastUsed: ls.clock}
+}
+
+//Reports whether the level exists yet, loaded or not.
+func (ls *LevelSet) HasLevel(id string) bool {
+	return ls.levels[id] != nil
+}
+
+//Reports whether the level is currently in memory.
+func (ls *LevelSet) IsLoaded(id string) bool {
+	l := ls.levels[id]
+	return l != nil && l.tileMap != nil
+}
+
+//Returns the map for a level, generating or reloading it if necessary. Levels loaded this way stay
+//loaded at least until the next SetActive(), so the map is safe to use until then.
+func (ls *LevelSet) GetLevel(id string) (*TileMap, error) {
+	l, err := ls.load(id)
+	if err != nil {
+		return nil, err
+	}
+	return l.tileMap, nil
+}
+
+//Makes the level the active one (the one the player is on), generating or reloading it if necessary.
+//Fires an EV_LEVEL_CHANGED event if the active level changes.
+func (ls *LevelSet) SetActive(id string) (*TileMap, error) {
+	l, err := ls.load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ls.active != id {
+		ls.active = id
+		PushEvent(NewEvent(EV_LEVEL_CHANGED, id))
+	}
+	ls.trim()
+	return l.tileMap, nil
+}
+
+//Returns the ID of the active level.
+func (ls *LevelSet) Active() string {
+	return ls.active
+}
+
+//Returns the active level's map, or nil if no level has been made active.
+func (ls *LevelSet) ActiveMap() *TileMap {
+	if l := ls.levels[ls.active]; l != nil {
+		return l.tileMap
+	}
+	return nil
+}
+
+//Connects two positions both ways, like a staircase. The levels don't have to exist yet.
+func (ls *LevelSet) Link(a, b LevelPos) {
+	ls.links[a] = b
+	ls.links[b] = a
+}
+
+//Connects a to b only. Good for trapdoors and teleporters.
+func (ls *LevelSet) LinkOneWay(a, b LevelPos) {
+	ls.links[a] = b
+}
+
+//Removes the connection leading from p. The connection back (if there is one) is left alone.
+func (ls *LevelSet) Unlink(p LevelPos) {
+	delete(ls.links, p)
+}
+
+//Returns where the connection at p leads, if there is one.
+func (ls *LevelSet) GetLink(p LevelPos) (LevelPos, bool) {
+	to, ok := ls.links[p]
+	return to, ok
+}
+
+//Returns all the connections leading from the level.
+func (ls *LevelSet) GetLinks(id string) (links []LevelPos) {
+	for from := range ls.links {
+		if from.Level == id {
+			links = append(links, from)
+		}
+	}
+	return
+}
+
+//Moves entity e from one level to another. The entity object itself moves, so whatever state it has
+//comes along. If e is blocking and the destination is occupied, it's placed on the nearest free
+//passable tile instead. Returns where e ended up.
+func (ls *LevelSet) TransferEntity(e Entity, from, to LevelPos) (LevelPos, error) {
+	src, err := ls.GetLevel(from.Level)
+	if err != nil {
+		return from, err
+	}
+	if !src.HasEntity(from.X, from.Y, e) {
+		return from, fmt.Errorf("Entity is not at (%d, %d) on level %q", from.X, from.Y, from.Level)
+	}
+
+	dst, err := ls.GetLevel(to.Level)
+	if err != nil {
+		return from, err
+	}
+
+	x, y, ok := dst.freeSpotNear(to.X, to.Y, isBlocking(e))
+	if !ok {
+		return from, fmt.Errorf("No room for entity near (%d, %d) on level %q", to.X, to.Y, to.Level)
+	}
+
+	src.RemoveEntityInstance(from.X, from.Y, e)
+	e.MoveTo(x, y)
+	dst.AddEntity(x, y, e)
+	return LevelPos{to.Level, x, y}, nil
+}
+
+//Moves e through the connection at p (where e must be standing). Returns where e ended up.
+func (ls *LevelSet) FollowLink(e Entity, p LevelPos) (LevelPos, error) {
+	to, ok := ls.links[p]
+	if !ok {
+		return p, fmt.Errorf("No connection at (%d, %d) on level %q", p.X, p.Y, p.Level)
+	}
+	return ls.TransferEntity(e, p, to)
+}
+
+//Finds the closest tile to (x, y) that a new entity could be put on. Blocking entities need a passable
+//tile with no blocking entity, others just need a tile on the map.
+func (m *TileMap) freeSpotNear(x, y int, blocking bool) (int, int, bool) {
+	free := func(x, y int) bool {
+		if !CheckBounds(x, y, m.Width, m.Height) {
+			return false
+		}
+		return !blocking || m.GetTile(x, y).Passable()
+	}
+
+	for r := 0; r < Max(m.Width, m.Height); r++ {
+		for dy := -r; dy <= r; dy++ {
+			for dx := -r; dx <= r; dx++ {
+				if Max(Abs(dx), Abs(dy)) == r && free(x+dx, y+dy) {
+					return x + dx, y + dy, true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+//Unloads a level, freeing its map. It's brought back as it was next time it's needed. The active level
+//can't be unloaded.
+func (ls *LevelSet) Unload(id string) error {
+	l := ls.levels[id]
+	if l == nil || l.tileMap == nil {
+		return nil
+	}
+	if id == ls.active {
+		return errors.New("Can't unload the active level")
+	}
+
+	m := l.tileMap
+	var b bytes.Buffer
+	if err := SaveMap(&b, m); err != nil {
+		return err
+	}
+
+	l.saved = b.Bytes()
+	l.things = m.takeThings()
+
+	l.lights, l.hadLights = nil, m.lights != nil
+	if m.lights != nil {
+		for _, s := range m.lights.GetSources() {
+			if s.emitter == nil {
+				l.lights = append(l.lights, LightSource{X: s.X, Y: s.Y, Light: s.Light})
+			}
+		}
+		m.lights.Detach()
+	}
+
+	l.tileMap = nil
+	return nil
+}
+
+//Gets a level into memory, from wherever it is.
+func (ls *LevelSet) load(id string) (*level, error) {
+	ls.clock++
+	l := ls.levels[id]
+
+	switch {
+	case l == nil:
+		if ls.generate == nil {
+			return nil, fmt.Errorf("No level %q, and no generator to make it", id)
+		}
+		m, err := ls.generate(ls, id)
+		if err != nil {
+			return nil, fmt.Errorf("Could not generate level %q: %s", id, err.Error())
+		}
+		if m == nil {
+			return nil, fmt.Errorf("Could not generate level %q: generator made no map", id)
+		}
+		l = &level{tileMap: m}
+		ls.levels[id] = l
+	case l.tileMap == nil:
+		m, err := LoadMap(bytes.NewReader(l.saved))
+		if err != nil {
+			return nil, fmt.Errorf("Could not reload level %q: %s", id, err.Error())
+		}
+		m.putThings(l.things)
+		if l.hadLights {
+			lm := NewLightManager(m)
+			for _, s := range l.lights {
+				lm.AddLight(s.X, s.Y, s.Light)
+			}
+		}
+		l.tileMap, l.saved, l.things, l.lights = m, nil, nil, nil
+	}
+
+	l.lastUsed = ls.clock
+	return l, nil
+}
+
+//Unloads the least recently used levels until only KeepLoaded are left.
+func (ls *LevelSet) trim() {
+	for {
+		loaded, oldest, found := 0, "", false
+		for id, l := range ls.levels {
+			if l.tileMap == nil {
+				continue
+			}
+			loaded++
+			if id != ls.active && (!found || l.lastUsed < ls.levels[oldest].lastUsed) {
+				oldest, found = id, true
+			}
+		}
+
+		if loaded <= Max(ls.KeepLoaded, 1) || !found {
+			return
+		}
+		if err := ls.Unload(oldest); err != nil {
+			LogError("Could not unload level ", oldest, ": ", err.Error())
+			return
+		}
+	}
+}
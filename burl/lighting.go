@@ -0,0 +1,134 @@
+package burl
+
+import "math"
+
+//LightFalloff describes how the intensity of a light drops off with distance from the source.
+type LightFalloff int
+
+const (
+	FALLOFF_QUADRATIC      LightFalloff = iota //the classic burl falloff. linear in distance squared, so a bright core with a quick edge.
+	FALLOFF_LINEAR                             //linear in distance.
+	FALLOFF_INVERSE_SQUARE                     //very bright near the source with a long dim tail. still reaches 0 at the radius.
+	FALLOFF_CONSTANT                           //full intensity all the way out to the radius.
+)
+
+//Returns the intensity (0-255) of a light with radius r at squared distance d from the source. d is
+//squared since that's what the shadowcaster delivers.
+func (f LightFalloff) Intensity(d, r int) int {
+	if r <= 0 {
+		if d == 0 {
+			return 255
+		}
+		return 0
+	}
+
+	var i int
+	switch f {
+	case FALLOFF_LINEAR:
+		i = 255 - int(255*math.Sqrt(float64(d))/float64(r))
+	case FALLOFF_INVERSE_SQUARE:
+		edge := 1 / float64(1+r*r)
+		i = int(255 * (1/float64(1+d) - edge) / (1 - edge))
+	case FALLOFF_CONSTANT:
+		i = 255
+	default:
+		i = 255 - int(255*float32(d)/float32(r*r))
+	}
+
+	return Clamp(i, 0, 255)
+}
+
+//Returns a Cast that adds coloured light c to the map, with intensity given by falloff f. Lights mix
+//additively on each tile. COL_NONE is treated as white.
+func LightenColour(c uint32, f LightFalloff) Cast {
+	return colourCast(c, f, 1)
+}
+
+//Returns a Cast that removes light added by LightenColour(c, f).
+func DarkenColour(c uint32, f LightFalloff) Cast {
+	return colourCast(c, f, -1)
+}
+
+func colourCast(c uint32, f LightFalloff, sign int) Cast {
+	if c == COL_NONE {
+		c = COL_WHITE
+	}
+	cr, cg, cb, _ := GetRGBA(c)
+
+	return func(m *TileMap, x, y, d, r int) {
+		i := f.Intensity(d, r)
+		m.Tiles[x+y*m.Width].Light.add(sign*i, sign*i*int(cr)/255, sign*i*int(cg)/255, sign*i*int(cb)/255)
+	}
+}
+
+//Adds to the brightness and colour accumulators of the light. Nothing goes below 0.
+func (tl *TileLight) add(bright, r, g, b int) {
+	tl.Bright = Max(tl.Bright+bright, 0)
+	tl.R = Max(tl.R+r, 0)
+	tl.G = Max(tl.G+g, 0)
+	tl.B = Max(tl.B+b, 0)
+}
+
+//Returns the mixed colour of all the light falling on the tile, with the tile's tint (if any) applied.
+func (tl TileLight) GetColour() uint32 {
+	c := MakeOpaqueColour(Clamp(tl.R, 0, 255), Clamp(tl.G, 0, 255), Clamp(tl.B, 0, 255))
+	if tl.Colour != COL_NONE {
+		c = BlendColours(c, tl.Colour|0xFF000000, BLEND_MULTIPLY)
+	}
+	return c
+}
+
+//An area of a map with its own ambient light level. Caves under a skylight, etc.
+type ambientRegion struct {
+	Rect
+	colour uint32
+}
+
+//Sets the ambient light for the whole map. Ambient light is added to every tile regardless of the
+//light sources around it. COL_NONE or COL_BLACK for none (the default).
+func (m *TileMap) SetAmbientLight(c uint32) {
+	m.ambient = c
+}
+
+//Sets the ambient light in an area of the map, overriding the map's global ambient light. Regions
+//can overlap, the most recently added one wins.
+func (m *TileMap) AddAmbientRegion(r Rect, c uint32) {
+	m.ambientRegions = append(m.ambientRegions, ambientRegion{r, c})
+}
+
+func (m *TileMap) ClearAmbientRegions() {
+	m.ambientRegions = nil
+}
+
+//Returns the ambient light level at (x, y).
+func (m *TileMap) GetAmbientLight(x, y int) uint32 {
+	for i := len(m.ambientRegions) - 1; i >= 0; i-- {
+		if IsInside(x, y, m.ambientRegions[i]) {
+			return m.ambientRegions[i].colour
+		}
+	}
+	return m.ambient
+}
+
+//Returns the final colour of the light at (x, y): the ambient light plus all the light from sources
+//shining on the tile. Returns black if (x, y) is out of bounds.
+func (m *TileMap) GetLight(x, y int) uint32 {
+	if !CheckBounds(x, y, m.Width, m.Height) {
+		return COL_BLACK
+	}
+
+	return BlendColours(m.Tiles[x+y*m.Width].Light.GetColour(), m.GetAmbientLight(x, y)|0xFF000000, BLEND_ADD)
+}
+
+//Returns the visuals of the tile at (x, y), with whatever is on top of it, lit by the light there.
+func (m *TileMap) GetLitVisuals(x, y int) Visuals {
+	return ApplyLight(m.GetTile(x, y).GetCompositeVisuals(), m.GetLight(x, y))
+}
+
+//Combines visuals with a light colour, producing the final fore and back colours to draw. Full white
+//light leaves the visuals unchanged, black light makes everything black.
+func ApplyLight(v Visuals, light uint32) Visuals {
+	v.ForeColour = BlendColours(v.ForeColour, light, BLEND_MULTIPLY)
+	v.BackColour = BlendColours(v.BackColour, light, BLEND_MULTIPLY)
+	return v
+}
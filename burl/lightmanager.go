@@ -0,0 +1,300 @@
+package burl
+
+import "math"
+
+type LightMode int
+
+const (
+	LIGHT_STEADY  LightMode = iota
+	LIGHT_FLICKER           //brightness jumps around randomly between 70% and 100%, changing every Period ticks.
+	LIGHT_PULSE             //brightness swells smoothly between 50% and 100% over Period ticks.
+)
+
+//A light on a map managed by a LightManager. Lights can be free-standing (added with AddLight()) or
+//come from entities and items on the map.
+type LightSource struct {
+	X, Y  int
+	Light EntityLight
+
+	emitter LightEmitter //entity or item giving off the light, if there is one
+	seed    int          //so flickering lights don't all flicker in unison
+	mod     int          //brightness modulation (0-255) last used to light the map
+	area    Rect         //area of the map last lit by this source
+}
+
+//LightManager takes over lighting for a TileMap. Rather than adding and subtracting light as things
+//move around (which drifts if walls change in between), it keeps track of all the light sources on the
+//map and recomputes the light from scratch in any area that has changed. Changes are collected until
+//the next Update(), so moving a bunch of things around in one turn only costs one recompute.
+//
+//Once a manager is attached to a map (see NewLightManager()), lights from entities and items added to
+//the map are tracked automatically, as are changes made with ChangeTileType() and SetTile(). If you
+//change an entity's light, or modify tiles directly, let the manager know with Refresh() or MarkDirty().
+type LightManager struct {
+	tileMap *TileMap
+	sources []*LightSource
+	tick    int
+	seeds   int
+
+	//the last emitter taken off the map and its seed. moving an entity takes it off and puts it back,
+	//so this lets it keep flickering the same way.
+	lastEmitter LightEmitter
+	lastSeed    int
+
+	dirty      Bitset
+	dirtyCount int
+}
+
+//Creates a light manager and attaches it to map m. Any lights already on the map are picked up and the
+//whole map is relit on the next Update().
+func NewLightManager(m *TileMap) *LightManager {
+	lm := &LightManager{tileMap: m, dirty: NewBitset(len(m.Tiles))}
+	m.lights = lm
+
+	for i, t := range m.Tiles {
+		for _, e := range t.GetEntities() {
+			lm.addEmitter(i%m.Width, i/m.Width, e)
+		}
+		for _, item := range t.items {
+			if le, ok := item.(LightEmitter); ok {
+				lm.addEmitter(i%m.Width, i/m.Width, le)
+			}
+		}
+	}
+
+	lm.MarkAllDirty()
+	return lm
+}
+
+//Detaches the manager from its map. The map goes back to incremental lighting.
+func (lm *LightManager) Detach() {
+	if lm.tileMap.lights == lm {
+		lm.tileMap.lights = nil
+	}
+}
+
+//Adds a free-standing light at (x, y). Returns the source so it can be moved, changed or removed later,
+//or nil if (x, y) is off the map.
+func (lm *LightManager) AddLight(x, y int, l EntityLight) *LightSource {
+	if !CheckBounds(x, y, lm.tileMap.Width, lm.tileMap.Height) {
+		LogError("Tried to add light off the map at ", x, ", ", y)
+		return nil
+	}
+
+	s := &LightSource{X: x, Y: y, Light: l, seed: lm.seeds}
+	lm.seeds++
+	lm.sources = append(lm.sources, s)
+	lm.markSource(s)
+
+	return s
+}
+
+func (lm *LightManager) RemoveLight(s *LightSource) {
+	for i := range lm.sources {
+		if lm.sources[i] == s {
+			lm.sources = append(lm.sources[:i], lm.sources[i+1:]...)
+			lm.markArea(s.area)
+			return
+		}
+	}
+}
+
+//Moves a light to (x, y). Lights can't be moved off the map.
+func (lm *LightManager) MoveLight(s *LightSource, x, y int) {
+	if s.X == x && s.Y == y {
+		return
+	}
+	if !CheckBounds(x, y, lm.tileMap.Width, lm.tileMap.Height) {
+		LogError("Tried to move light off the map to ", x, ", ", y)
+		return
+	}
+
+	lm.markArea(s.area)
+	s.X, s.Y = x, y
+	lm.markSource(s)
+}
+
+func (lm *LightManager) SetLight(s *LightSource, l EntityLight) {
+	lm.markArea(s.area)
+	s.Light = l
+	lm.markSource(s)
+}
+
+//Returns all light sources on the map, in the order they were added.
+func (lm *LightManager) GetSources() []*LightSource {
+	return append([]*LightSource(nil), lm.sources...)
+}
+
+//Re-reads the light from an entity or item on the map. Call this after changing an entity's light.
+func (lm *LightManager) Refresh(e LightEmitter) {
+	if s := lm.findEmitter(e); s != nil {
+		lm.SetLight(s, e.GetLight())
+	}
+}
+
+func (lm *LightManager) addEmitter(x, y int, e LightEmitter) {
+	s := lm.AddLight(x, y, e.GetLight())
+	if s == nil {
+		return
+	}
+	s.emitter = e
+	if lm.lastEmitter == e {
+		s.seed = lm.lastSeed
+	}
+	lm.lastEmitter = nil
+}
+
+func (lm *LightManager) removeEmitter(e LightEmitter) {
+	if s := lm.findEmitter(e); s != nil {
+		lm.RemoveLight(s)
+		lm.lastEmitter, lm.lastSeed = e, s.seed
+	}
+}
+
+func (lm *LightManager) findEmitter(e LightEmitter) *LightSource {
+	for _, s := range lm.sources {
+		if s.emitter == e {
+			return s
+		}
+	}
+	return nil
+}
+
+//Lets the manager know the tile at (x, y) has changed. The light from every source that could reach
+//(x, y) will be recomputed.
+func (lm *LightManager) MarkDirty(x, y int) {
+	lm.markArea(Rect{1, 1, x, y})
+	for _, s := range lm.sources {
+		if Distance(s.X, s.Y, x, y) < s.Light.Strength*s.Light.Strength {
+			lm.markSource(s)
+		}
+	}
+}
+
+//Marks the whole map for relighting.
+func (lm *LightManager) MarkAllDirty() {
+	lm.markArea(Rect{lm.tileMap.Width, lm.tileMap.Height, 0, 0})
+}
+
+//Marks the area a source lights (or would light) for recomputing.
+func (lm *LightManager) markSource(s *LightSource) {
+	r := s.Light.Strength
+	lm.markArea(Rect{2*r + 1, 2*r + 1, s.X - r, s.Y - r})
+}
+
+func (lm *LightManager) markArea(r Rect) {
+	for y := Max(r.Y, 0); y < Min(r.Y+r.H, lm.tileMap.Height); y++ {
+		for x := Max(r.X, 0); x < Min(r.X+r.W, lm.tileMap.Width); x++ {
+			if i := x + y*lm.tileMap.Width; !lm.dirty.Get(i) {
+				lm.dirty.Set(i)
+				lm.dirtyCount++
+			}
+		}
+	}
+}
+
+//Advances flickering and pulsing lights to the given tick, then recomputes the light in all dirty areas.
+//Call this once per turn (or frame, if your lights need to animate smoothly).
+func (lm *LightManager) Update(tick int) {
+	lm.tick = tick
+	for _, s := range lm.sources {
+		if s.Light.Mode != LIGHT_STEADY && s.Light.modulation(tick, s.seed) != s.mod {
+			lm.markSource(s)
+		}
+	}
+
+	lm.Recompute()
+}
+
+//Recomputes the light in all dirty areas from scratch. Tile tints (Light.Colour) are left alone.
+func (lm *LightManager) Recompute() {
+	if lm.dirtyCount == 0 {
+		return
+	}
+
+	m := lm.tileMap
+	for i := range m.Tiles {
+		if lm.dirty.Get(i) {
+			m.Tiles[i].Light.Bright = 0
+			m.Tiles[i].Light.R, m.Tiles[i].Light.G, m.Tiles[i].Light.B = 0, 0, 0
+		}
+	}
+
+	for _, s := range lm.sources {
+		r := s.Light.Strength
+		s.area = Rect{2*r + 1, 2*r + 1, s.X - r, s.Y - r}
+		if lm.areaDirty(s.area) {
+			lm.cast(s)
+		}
+	}
+
+	lm.dirty.Clear()
+	lm.dirtyCount = 0
+}
+
+func (lm *LightManager) areaDirty(r Rect) bool {
+	for y := Max(r.Y, 0); y < Min(r.Y+r.H, lm.tileMap.Height); y++ {
+		for x := Max(r.X, 0); x < Min(r.X+r.W, lm.tileMap.Width); x++ {
+			if lm.dirty.Get(x + y*lm.tileMap.Width) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Adds the light from source s to the dirty tiles it reaches.
+func (lm *LightManager) cast(s *LightSource) {
+	l := s.Light
+	c := l.Colour
+	if c == COL_NONE {
+		c = COL_WHITE
+	}
+	cr, cg, cb, _ := GetRGBA(c)
+	s.mod = l.modulation(lm.tick, s.seed)
+
+	lm.tileMap.ShadowCast(s.X, s.Y, l.Strength, func(m *TileMap, x, y, d, r int) {
+		if !lm.dirty.Get(x+y*m.Width) || !l.InCone(x-s.X, y-s.Y) {
+			return
+		}
+		i := l.Falloff.Intensity(d, r) * s.mod / 255
+		m.Tiles[x+y*m.Width].Light.add(i, i*int(cr)/255, i*int(cg)/255, i*int(cb)/255)
+	})
+}
+
+//Reports whether the offset (dx, dy) from the light is inside its cone. Lights with an Arc of 0 or
+//360+ shine in all directions. The light's own tile is always lit.
+func (l EntityLight) InCone(dx, dy int) bool {
+	if l.Arc <= 0 || l.Arc >= 360 || (dx == 0 && dy == 0) {
+		return true
+	}
+
+	diff := math.Mod(math.Atan2(float64(dy), float64(dx))*180/math.Pi-float64(l.Direction), 360)
+	if diff > 180 {
+		diff -= 360
+	} else if diff < -180 {
+		diff += 360
+	}
+
+	return math.Abs(diff) <= float64(l.Arc)/2
+}
+
+//Returns the brightness (0-255) of the light at the given tick, according to its Mode.
+func (l EntityLight) modulation(tick, seed int) int {
+	p := Max(l.Period, 1)
+	switch l.Mode {
+	case LIGHT_FLICKER:
+		return 179 + lightNoise(seed, tick/p)*76/255
+	case LIGHT_PULSE:
+		return 191 + RoundFloatToInt(64*math.Cos(2*math.Pi*float64(tick%p)/float64(p)))
+	default:
+		return 255
+	}
+}
+
+//Cheap deterministic noise (0-255) so flickering is the same no matter how often the light is recomputed.
+func lightNoise(seed, n int) int {
+	h := uint32(seed)*374761393 + uint32(n)*668265263
+	h = (h ^ (h >> 13)) * 1274126177
+	return int((h ^ (h >> 16)) & 0xFF)
+}
@@ -0,0 +1,188 @@
+package burl
+
+//Line of sight and projectile paths. Ranged attacks, thrown items and the targeting cursor should all
+//use Trace() (or TargetPath()) with the same options, so what the player sees previewed is exactly
+//where things go.
+
+type LineMode int
+
+const (
+	LINE_BRESENHAM  LineMode = iota //one tile per step, like DrawLine(). can slip diagonally between walls.
+	LINE_SUPERCOVER                 //every tile the line touches, like DrawSupercoverLine(). can't slip through corners.
+)
+
+//What stops a trace. Combine with |.
+type TraceStop int
+
+const (
+	STOP_OPAQUE     TraceStop = 1 << iota //tiles that block sight
+	STOP_IMPASSABLE                       //tiles that can't be walked on
+	STOP_ENTITY                           //tiles with a blocking entity
+
+	STOP_PROJECTILE = STOP_IMPASSABLE | STOP_ENTITY //what an arrow or thrown rock usually runs into
+)
+
+type TraceOptions struct {
+	Mode  LineMode
+	Stop  TraceStop
+	Range int  //works like an FOV radius: tiles at squared distance >= Range*Range are out of reach. 0 for no limit.
+	Past  bool //keep going past the end point until something is hit or Range runs out. needs a Range.
+}
+
+//The result of a trace.
+type TraceResult struct {
+	Path    []Coord //tiles passed through, not including the start, up to and including the tile where the trace stopped
+	Blocked bool    //the trace hit something. the last tile in Path is the thing it hit.
+	Entity  Entity  //the blocking entity hit, if there was one
+}
+
+//Returns the last tile reached by the trace, or start if it didn't get anywhere.
+func (tr TraceResult) End(start Coord) Coord {
+	if len(tr.Path) == 0 {
+		return start
+	}
+	return tr.Path[len(tr.Path)-1]
+}
+
+//Reports whether the trace made it all the way to c without being stopped before it. Hitting c
+//itself (an entity standing there, say) counts.
+func (tr TraceResult) Reached(c Coord) bool {
+	for _, p := range tr.Path {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the points on the line from start to end (not including start) in the given mode.
+func LinePoints(start, end Coord, mode LineMode) (points []Coord) {
+	draw := DrawLine
+	if mode == LINE_SUPERCOVER {
+		draw = DrawSupercoverLine
+	}
+
+	draw(start, end, func(x, y int) {
+		if x != start.X || y != start.Y {
+			points = append(points, Coord{x, y})
+		}
+	})
+	return
+}
+
+//Traces a line from start towards end, stopping at the first tile that blocks it (as per opts.Stop),
+//the edge of the map, or when it runs out of range. The start tile never blocks, so a shooter doesn't
+//hit themselves.
+func (m *TileMap) Trace(start, end Coord, opts TraceOptions) TraceResult {
+	if opts.Past && opts.Range > 0 && end != start {
+		//stretch the line so it reaches at least Range tiles
+		dx, dy := end.X-start.X, end.Y-start.Y
+		k := opts.Range/Max(Abs(dx), Abs(dy)) + 1
+		end = Coord{start.X + dx*k, start.Y + dy*k}
+	}
+
+	return m.tracePoints(start, LinePoints(start, end, opts.Mode), opts)
+}
+
+func (m *TileMap) tracePoints(start Coord, points []Coord, opts TraceOptions) (tr TraceResult) {
+	for _, c := range points {
+		if !CheckBounds(c.X, c.Y, m.Width, m.Height) {
+			return
+		}
+		if opts.Range > 0 && Distance(start.X, start.Y, c.X, c.Y) >= opts.Range*opts.Range {
+			return
+		}
+
+		tr.Path = append(tr.Path, c)
+		if e, blocked := m.stops(c, opts.Stop); blocked {
+			tr.Blocked, tr.Entity = true, e
+			return
+		}
+	}
+	return
+}
+
+//Reports whether the tile at c stops a trace, and the entity responsible if there is one.
+func (m *TileMap) stops(c Coord, stop TraceStop) (Entity, bool) {
+	t := m.Tiles[c.X+c.Y*m.Width]
+	if stop&STOP_ENTITY != 0 && t.entity != nil {
+		return t.entity, true
+	}
+	if stop&STOP_OPAQUE != 0 && !t.Transparent() {
+		return nil, true
+	}
+	if stop&STOP_IMPASSABLE != 0 && !IsPassable(t.TileType) {
+		return nil, true
+	}
+	return nil, false
+}
+
+//Reports whether target is within radius of from and can be seen from there using fov. This is the
+//same test the player's view uses, so anything the player can see can be targeted.
+func (m *TileMap) CanTarget(from, target Coord, radius int, fov FOV) bool {
+	if Distance(from.X, from.Y, target.X, target.Y) >= radius*radius {
+		return false
+	}
+
+	seen := false
+	fov.Cast(m, from.X, from.Y, radius, func(m *TileMap, x, y, d, r int) {
+		if x == target.X && y == target.Y {
+			seen = true
+		}
+	})
+	return seen
+}
+
+//Finds a path for a projectile from start to target. The plain line is tried first; if something's in
+//the way, the reversed line and lines from slightly offset points in the start tile are tried, since
+//FOVs are usually more generous than a single line and the player expects to hit what they can see.
+//(Supercover lines only get the reversed line, since the offset lines would skip through corners.)
+//Returns the first trace that reaches the target, or the plain line's trace if none do. opts.Past is
+//honoured for the plain line only.
+func (m *TileMap) TargetPath(start, target Coord, opts TraceOptions) TraceResult {
+	tr := m.Trace(start, target, opts)
+	if tr.Reached(target) || start == target {
+		return tr
+	}
+
+	for _, points := range alternateLines(start, target, opts.Mode) {
+		if alt := m.tracePoints(start, points, opts); alt.Reached(target) {
+			return alt
+		}
+	}
+	return tr
+}
+
+//Other reasonable lines from start to target: the line drawn backwards, and lines from points near the
+//corners of the start tile.
+func alternateLines(start, target Coord, mode LineMode) (lines [][]Coord) {
+	back := LinePoints(target, start, mode)
+	reversed := make([]Coord, 0, len(back))
+	for i := len(back) - 2; i >= 0; i-- {
+		reversed = append(reversed, back[i])
+	}
+	lines = append(lines, append(reversed, target))
+	if mode == LINE_SUPERCOVER {
+		return //offset lines would skip through corners
+	}
+
+	for _, o := range [4][2]float64{{-0.4, -0.4}, {0.4, -0.4}, {-0.4, 0.4}, {0.4, 0.4}} {
+		lines = append(lines, offsetLine(start, target, o[0], o[1]))
+	}
+	return
+}
+
+//Steps along the line from (start + offset) to the centre of target, one tile per step along the major
+//axis. Doesn't include start.
+func offsetLine(start, target Coord, ox, oy float64) (points []Coord) {
+	dx, dy := float64(target.X-start.X)-ox, float64(target.Y-start.Y)-oy
+	n := Max(Abs(target.X-start.X), Abs(target.Y-start.Y))
+	for i := 1; i <= n; i++ {
+		f := float64(i) / float64(n)
+		c := Coord{start.X + RoundFloatToInt(ox+dx*f), start.Y + RoundFloatToInt(oy+dy*f)}
+		if c != start && (len(points) == 0 || points[len(points)-1] != c) {
+			points = append(points, c)
+		}
+	}
+	return
+}
@@ -0,0 +1,356 @@
+package burl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Maps are saved as a small header (magic string, format version, the game's data version, dimensions)
+//followed by a table of the names of the tile types used in the map and then the tiles themselves,
+//run-length encoded. Tiles refer to their type by its position in the name table, so maps survive
+//changes to the order tile types are loaded in. Entities and items are NOT saved, the game is
+//responsible for those. The map's own memory (Tile.LastVisible) is saved, but the memory of observers
+//with their own Vision is not: after loading, those observers have forgotten everything.
+
+//Version of the binary map format. Bumped whenever the layout changes. Old versions can still be read.
+const MAP_FORMAT_VERSION = 1
+
+var mapMagic = []byte("BURLMAP\x00")
+
+//The version of the game's map data, see SetMapDataVersion().
+var mapDataVersion int
+var mapMigrations map[int]MapMigration
+
+//A MapMigration upgrades map data saved by an older version of the game. See RegisterMapMigration().
+type MapMigration func(md *MapData) error
+
+//Sets the version of the game's map data. This is stored in saved maps, and when a map saved with an
+//older version is loaded the migrations registered for each version in between are run on it. Bump this
+//whenever you rename tile types or change what variants mean, etc.
+func SetMapDataVersion(v int) {
+	mapDataVersion = v
+}
+
+//Registers a migration that upgrades map data from version-1 to version. Migrations are run in order,
+//so a map saved at version 2 loaded by a game at version 5 runs migrations 3, 4 and 5.
+func RegisterMapMigration(version int, fn MapMigration) {
+	if mapMigrations == nil {
+		mapMigrations = make(map[int]MapMigration)
+	}
+	mapMigrations[version] = fn
+}
+
+//MapData is the saved form of a TileMap. Migrations get to modify this before it's turned back into a map.
+type MapData struct {
+	Version        int //map format version
+	DataVersion    int //game's map data version
+	Width, Height  int
+	TileTypes      []string //names of the tile types used in the map. tiles refer to these by index.
+	Tiles          []TileData
+	Ambient        uint32
+	AmbientRegions []AmbientRegionData
+}
+
+type TileData struct {
+	Type        int //index into MapData.TileTypes
+	Variant     int
+	LastVisible int
+	Light       TileLight
+}
+
+type AmbientRegionData struct {
+	Area   Rect
+	Colour uint32
+}
+
+//Converts the map to its saveable form.
+func (m *TileMap) ToMapData() *MapData {
+	md := &MapData{
+		Version:     MAP_FORMAT_VERSION,
+		DataVersion: mapDataVersion,
+		Width:       m.Width,
+		Height:      m.Height,
+		Tiles:       make([]TileData, len(m.Tiles)),
+		Ambient:     m.ambient,
+	}
+
+	types := make(map[int]int) //tile type -> index in name table
+	for i, t := range m.Tiles {
+		n, ok := types[t.TileType]
+		if !ok {
+			n = len(md.TileTypes)
+			types[t.TileType] = n
+			md.TileTypes = append(md.TileTypes, GetName(t.TileType))
+		}
+		md.Tiles[i] = TileData{n, t.Variant, t.LastVisible, t.Light}
+	}
+
+	for _, r := range m.ambientRegions {
+		md.AmbientRegions = append(md.AmbientRegions, AmbientRegionData{r.Rect, r.colour})
+	}
+
+	return md
+}
+
+//Builds a map from saved map data, running any migrations needed first. All tile types used by the
+//map must be loaded.
+func NewMapFromData(md *MapData) (*TileMap, error) {
+	if md.DataVersion > mapDataVersion {
+		return nil, fmt.Errorf("Map data version %d is newer than this game's (%d)", md.DataVersion, mapDataVersion)
+	}
+
+	for v := md.DataVersion + 1; v <= mapDataVersion; v++ {
+		if fn := mapMigrations[v]; fn != nil {
+			if err := fn(md); err != nil {
+				return nil, fmt.Errorf("Map migration to version %d failed: %s", v, err.Error())
+			}
+		}
+		md.DataVersion = v
+	}
+
+	if md.Width <= 0 || md.Height <= 0 || len(md.Tiles) != md.Width*md.Height {
+		return nil, fmt.Errorf("Bad map data: %d tiles for a %dx%d map", len(md.Tiles), md.Width, md.Height)
+	}
+
+	types := make([]int, len(md.TileTypes))
+	for i, name := range md.TileTypes {
+		t, ok := GetTileType(name)
+		if !ok {
+			return nil, fmt.Errorf("Map uses unknown tile type %q", name)
+		}
+		types[i] = t
+	}
+
+	m := NewMap(md.Width, md.Height)
+	for i, td := range md.Tiles {
+		if td.Type < 0 || td.Type >= len(types) {
+			return nil, fmt.Errorf("Bad map data: tile %d has type %d, only %d types in table", i, td.Type, len(types))
+		}
+		m.Tiles[i] = Tile{TileType: types[td.Type], Variant: td.Variant, LastVisible: td.LastVisible, Light: td.Light}
+	}
+
+	m.ambient = md.Ambient
+	for _, r := range md.AmbientRegions {
+		m.AddAmbientRegion(r.Area, r.Colour)
+	}
+
+	return m, nil
+}
+
+//Writes the map to w in the binary map format.
+func SaveMap(w io.Writer, m *TileMap) error {
+	md := m.ToMapData()
+	mw := &mapWriter{w: bufio.NewWriter(w)}
+
+	mw.w.Write(mapMagic)
+	mw.putUint(uint64(md.Version))
+	mw.putInt(int64(md.DataVersion))
+	mw.putUint(uint64(md.Width))
+	mw.putUint(uint64(md.Height))
+
+	mw.putUint(uint64(len(md.TileTypes)))
+	for _, name := range md.TileTypes {
+		mw.putUint(uint64(len(name)))
+		mw.w.WriteString(name)
+	}
+
+	//tiles, as runs of identical tiles
+	for i := 0; i < len(md.Tiles); {
+		run := 1
+		for i+run < len(md.Tiles) && md.Tiles[i+run] == md.Tiles[i] {
+			run++
+		}
+
+		t := md.Tiles[i]
+		mw.putUint(uint64(run))
+		mw.putUint(uint64(t.Type))
+		mw.putInt(int64(t.Variant))
+		mw.putInt(int64(t.LastVisible))
+		mw.putUint(uint64(t.Light.Colour))
+		mw.putInt(int64(t.Light.Bright))
+		mw.putInt(int64(t.Light.R))
+		mw.putInt(int64(t.Light.G))
+		mw.putInt(int64(t.Light.B))
+		i += run
+	}
+
+	mw.putUint(uint64(md.Ambient))
+	mw.putUint(uint64(len(md.AmbientRegions)))
+	for _, r := range md.AmbientRegions {
+		mw.putInt(int64(r.Area.X))
+		mw.putInt(int64(r.Area.Y))
+		mw.putInt(int64(r.Area.W))
+		mw.putInt(int64(r.Area.H))
+		mw.putUint(uint64(r.Colour))
+	}
+
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+//Reads a map in the binary map format from r.
+func LoadMap(r io.Reader) (*TileMap, error) {
+	md, err := readMapData(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	return NewMapFromData(md)
+}
+
+//Writes the map to w as JSON. Much bigger than the binary format, but you can read it.
+func SaveMapJSON(w io.Writer, m *TileMap) error {
+	return json.NewEncoder(w).Encode(m.ToMapData())
+}
+
+//Reads a map saved with SaveMapJSON() from r.
+func LoadMapJSON(r io.Reader) (*TileMap, error) {
+	md := new(MapData)
+	if err := json.NewDecoder(r).Decode(md); err != nil {
+		return nil, err
+	}
+	if md.Version > MAP_FORMAT_VERSION {
+		return nil, fmt.Errorf("Map format version %d is newer than supported (%d)", md.Version, MAP_FORMAT_VERSION)
+	}
+	return NewMapFromData(md)
+}
+
+//So maps can be saved with encoding/gob as part of a larger save game.
+func (m *TileMap) GobEncode() ([]byte, error) {
+	var b bytes.Buffer
+	err := SaveMap(&b, m)
+	return b.Bytes(), err
+}
+
+func (m *TileMap) GobDecode(data []byte) error {
+	nm, err := LoadMap(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*m = *nm
+	return nil
+}
+
+func readMapData(r *bufio.Reader) (*MapData, error) {
+	magic := make([]byte, len(mapMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, mapMagic) {
+		return nil, errors.New("Not a burl map file")
+	}
+
+	mr := &mapReader{r: r}
+	md := new(MapData)
+	md.Version = int(mr.getUint())
+	switch md.Version {
+	case 1:
+		//current version. when the format changes, keep a reader for the old layout here.
+	default:
+		return nil, fmt.Errorf("Unsupported map format version %d (this version of burl reads up to %d)", md.Version, MAP_FORMAT_VERSION)
+	}
+
+	md.DataVersion = int(mr.getInt())
+	md.Width, md.Height = int(mr.getUint()), int(mr.getUint())
+	if mr.err != nil {
+		return nil, mr.err
+	}
+	if md.Width <= 0 || md.Height <= 0 || md.Width > 1<<16 || md.Height > 1<<16 {
+		return nil, fmt.Errorf("Bad map dimensions %dx%d", md.Width, md.Height)
+	}
+
+	md.TileTypes = make([]string, mr.getCount(md.Width*md.Height))
+	for i := range md.TileTypes {
+		name := make([]byte, mr.getCount(1<<16))
+		if mr.err == nil {
+			_, mr.err = io.ReadFull(r, name)
+		}
+		md.TileTypes[i] = string(name)
+	}
+
+	md.Tiles = make([]TileData, 0, md.Width*md.Height)
+	for len(md.Tiles) < md.Width*md.Height && mr.err == nil {
+		run := mr.getCount(md.Width*md.Height - len(md.Tiles))
+		var t TileData
+		t.Type = int(mr.getUint())
+		t.Variant = int(mr.getInt())
+		t.LastVisible = int(mr.getInt())
+		t.Light.Colour = uint32(mr.getUint())
+		t.Light.Bright = int(mr.getInt())
+		t.Light.R, t.Light.G, t.Light.B = int(mr.getInt()), int(mr.getInt()), int(mr.getInt())
+		if run == 0 && mr.err == nil {
+			mr.err = errors.New("Bad map data: empty run of tiles")
+		}
+		for i := 0; i < run; i++ {
+			md.Tiles = append(md.Tiles, t)
+		}
+	}
+
+	md.Ambient = uint32(mr.getUint())
+	md.AmbientRegions = make([]AmbientRegionData, mr.getCount(1<<16))
+	for i := range md.AmbientRegions {
+		a := &md.AmbientRegions[i]
+		a.Area.X, a.Area.Y = int(mr.getInt()), int(mr.getInt())
+		a.Area.W, a.Area.H = int(mr.getInt()), int(mr.getInt())
+		a.Colour = uint32(mr.getUint())
+	}
+
+	if mr.err != nil {
+		return nil, fmt.Errorf("Could not read map: %s", mr.err.Error())
+	}
+	return md, nil
+}
+
+//Writes varints, remembering the first error so we don't have to check every write.
+type mapWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (mw *mapWriter) putUint(v uint64) {
+	if mw.err == nil {
+		_, mw.err = mw.w.Write(mw.buf[:binary.PutUvarint(mw.buf[:], v)])
+	}
+}
+
+func (mw *mapWriter) putInt(v int64) {
+	if mw.err == nil {
+		_, mw.err = mw.w.Write(mw.buf[:binary.PutVarint(mw.buf[:], v)])
+	}
+}
+
+//Reads varints, remembering the first error. Once there's an error everything reads as 0.
+type mapReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (mr *mapReader) getUint() (v uint64) {
+	if mr.err == nil {
+		v, mr.err = binary.ReadUvarint(mr.r)
+	}
+	return
+}
+
+func (mr *mapReader) getInt() (v int64) {
+	if mr.err == nil {
+		v, mr.err = binary.ReadVarint(mr.r)
+	}
+	return
+}
+
+//Reads a length or count, which can't be more than max. Stops corrupt files from allocating the world.
+func (mr *mapReader) getCount(max int) int {
+	v := mr.getUint()
+	if mr.err == nil && v > uint64(max) {
+		mr.err = fmt.Errorf("count %d out of range (max %d)", v, max)
+	}
+	if mr.err != nil {
+		return 0
+	}
+	return int(v)
+}
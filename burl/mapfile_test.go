@@ -0,0 +1,156 @@
+package burl
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+//Builds a map with a bit of everything that gets saved: a mix of tile types, variants, remembered
+//tiles, coloured light, tints, ambient light and ambient regions.
+func savedTestMap() *TileMap {
+	loadTestTiles()
+	r := rand.New(rand.NewSource(5))
+	m := testMaps(5)[1]
+	for i := 0; i < 100; i++ {
+		t := r.Intn(len(m.Tiles))
+		m.Tiles[t].Variant = r.Intn(3)
+		m.Tiles[t].LastVisible = r.Intn(100) + 1
+	}
+	m.ShadowCast(10, 10, 8, LightenColour(COL_RED, FALLOFF_LINEAR))
+	m.ShadowCast(3, 15, 5, LightenColour(COL_BLUE, FALLOFF_QUADRATIC))
+	m.ChangeTileColour(3, 3, COL_LIME)
+	m.SetAmbientLight(0xFF101010)
+	m.AddAmbientRegion(Rect{4, 5, 6, 2}, COL_PURPLE)
+	return m
+}
+
+//Fails the test unless loaded is the same as m in every way that's saved.
+func checkSameMap(t *testing.T, how string, m, loaded *TileMap) {
+	if loaded == nil || loaded.Width != m.Width || loaded.Height != m.Height {
+		t.Fatalf("%s: map is the wrong size", how)
+	}
+	for i, tile := range m.Tiles {
+		lt := loaded.Tiles[i]
+		if lt.TileType != tile.TileType || lt.Variant != tile.Variant || lt.LastVisible != tile.LastVisible || lt.Light != tile.Light {
+			t.Fatalf("%s: tile %d is %+v, should be %+v", how, i, lt, tile)
+		}
+	}
+	for i := range m.Tiles {
+		x, y := i%m.Width, i/m.Width
+		if loaded.GetLight(x, y) != m.GetLight(x, y) {
+			t.Fatalf("%s: light at (%d, %d) is %x, should be %x", how, x, y, loaded.GetLight(x, y), m.GetLight(x, y))
+		}
+	}
+	if !reflect.DeepEqual(loaded.ToMapData(), m.ToMapData()) {
+		t.Fatalf("%s: map data doesn't match", how)
+	}
+}
+
+func TestMapRoundTripBinary(t *testing.T) {
+	m := savedTestMap()
+	var b bytes.Buffer
+	if err := SaveMap(&b, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMap(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameMap(t, "binary", m, loaded)
+
+	//cut off files should give errors, not panics
+	for n := 0; n < b.Len(); n += 7 {
+		if _, err := LoadMap(bytes.NewReader(b.Bytes()[:n])); err == nil {
+			t.Fatalf("loading the first %d bytes of %d didn't fail", n, b.Len())
+		}
+	}
+}
+
+func TestMapRoundTripJSON(t *testing.T) {
+	m := savedTestMap()
+	var b bytes.Buffer
+	if err := SaveMapJSON(&b, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMapJSON(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameMap(t, "JSON", m, loaded)
+}
+
+func TestMapRoundTripGob(t *testing.T) {
+	type saveGame struct {
+		Map  *TileMap
+		Turn int
+	}
+
+	m := savedTestMap()
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(saveGame{m, 7}); err != nil {
+		t.Fatal(err)
+	}
+	var loaded saveGame
+	if err := gob.NewDecoder(&b).Decode(&loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Turn != 7 {
+		t.Fatal("gob: rest of the save game wasn't loaded")
+	}
+	checkSameMap(t, "gob", m, loaded.Map)
+}
+
+//Saves a map at data version 0 using a tile type that version 1 of the "game" replaced, then loads it
+//with a migration registered.
+func TestMapMigration(t *testing.T) {
+	loadTestTiles()
+	retired, ok := GetTileType("Test Retired Floor")
+	if !ok {
+		retired = LoadTileData("Test Retired Floor", true, true, GLYPH_PERIOD, COL_GREY)
+	}
+	defer SetMapDataVersion(0)
+	defer delete(mapMigrations, 1)
+
+	m := NewMap(4, 3)
+	m.fillTiles(testWall)
+	m.ChangeTileType(1, 1, retired)
+	m.ChangeTileType(2, 1, testFloor)
+	var b bytes.Buffer
+	if err := SaveMap(&b, m); err != nil {
+		t.Fatal(err)
+	}
+
+	//no migration, so the old type is still there
+	old, err := LoadMap(bytes.NewReader(b.Bytes()))
+	if err != nil || old.GetTileType(1, 1) != retired {
+		t.Fatal("loading without a migration changed the map", err)
+	}
+
+	SetMapDataVersion(1)
+	RegisterMapMigration(1, func(md *MapData) error {
+		for i, name := range md.TileTypes {
+			if name == "Test Retired Floor" {
+				md.TileTypes[i] = "Test Floor"
+			}
+		}
+		return nil
+	})
+	upgraded, err := LoadMap(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.GetTileType(1, 1) != testFloor || upgraded.GetTileType(2, 1) != testFloor || upgraded.GetTileType(0, 0) != testWall {
+		t.Fatal("migration wasn't applied")
+	}
+
+	//maps from newer versions of the game can't be loaded
+	b.Reset()
+	SaveMap(&b, upgraded)
+	SetMapDataVersion(0)
+	if _, err := LoadMap(bytes.NewReader(b.Bytes())); err == nil {
+		t.Fatal("loaded a map from a newer data version")
+	}
+}
@@ -0,0 +1,215 @@
+package burl
+
+//Camera describes a rectangular window onto a map. (X, Y) is the map coordinate shown at the top-left
+//of the window. The camera can follow a target, only moving when the target leaves the dead zone, a
+//rect centered in the window. A dead zone of 0x0 keeps the target centered at all times.
+type Camera struct {
+	X, Y         int
+	W, H         int
+	deadW, deadH int
+}
+
+func NewCamera(w, h int) Camera {
+	return Camera{W: w, H: h}
+}
+
+//Sets the size of the dead zone. The target can move freely inside the dead zone without moving the camera.
+func (c *Camera) SetDeadZone(w, h int) {
+	c.deadW, c.deadH = Clamp(w, 0, c.W), Clamp(h, 0, c.H)
+}
+
+//Centers the camera on the map coordinate (x, y).
+func (c *Camera) CenterOn(x, y int) {
+	c.X, c.Y = x-c.W/2, y-c.H/2
+}
+
+//Moves the camera as little as possible to keep (x, y) inside the dead zone.
+func (c *Camera) Follow(x, y int) {
+	c.X = followAxis(c.X, x, c.W, c.deadW)
+	c.Y = followAxis(c.Y, y, c.H, c.deadH)
+}
+
+func followAxis(pos, target, size, dead int) int {
+	dead = Max(dead, 1)
+	min := pos + (size-dead+1)/2 //so a 1 tile dead zone is where CenterOn() puts the target
+	max := min + dead - 1
+
+	if target < min {
+		return pos - (min - target)
+	} else if target > max {
+		return pos + (target - max)
+	}
+
+	return pos
+}
+
+//Keeps the camera within a map of size (w, h). If the map is smaller than the camera in some
+//dimension, the map is centered in that dimension instead.
+func (c *Camera) Clamp(w, h int) {
+	c.X = clampAxis(c.X, c.W, w)
+	c.Y = clampAxis(c.Y, c.H, h)
+}
+
+func clampAxis(pos, size, mapSize int) int {
+	if mapSize <= size {
+		return (mapSize - size) / 2
+	}
+	return Clamp(pos, 0, mapSize-size)
+}
+
+//Converts coordinates relative to the camera window to map coordinates.
+func (c Camera) ViewToMap(x, y int) (int, int) {
+	return x + c.X, y + c.Y
+}
+
+//Converts map coordinates to coordinates relative to the camera window. Check InView() first if you
+//need to know the result is actually in the window.
+func (c Camera) MapToView(x, y int) (int, int) {
+	return x - c.X, y - c.Y
+}
+
+//Reports whether map coordinate (x, y) is in the camera's window.
+func (c Camera) InView(x, y int) bool {
+	return CheckBounds(x-c.X, y-c.Y, c.W, c.H)
+}
+
+//MapView is a TileView that draws a window of a TileMap through a camera. Tiles are drawn with their
+//entities and items on top (see Tile.TopDrawable()), optionally lit by the light on the tile. If FOV is on, only tiles visible
+//on the current tick (see TileMap.SetVisible()) are drawn normally: previously seen tiles are drawn
+//from memory with MemoryTint applied and no entities, and unseen tiles are left blank. Visibility can
+//also come from a particular observer's Vision, see SetVision().
+type MapView struct {
+	TileView
+	Camera Camera
+
+	tileMap     *TileMap
+	FOV         bool   //use the visibility info in the map to decide what to draw
+	UseLighting bool   //colour tiles according to the light on them, see TileMap.GetLight()
+	MemoryTint  uint32 //multiplied into the colours of remembered tiles
+
+	vision *Vision //if set, FOV and memory come from here instead of the map
+
+	drawn []Visuals //what was drawn last time, so we only touch the cells that have changed
+	valid []bool
+}
+
+func NewMapView(w, h, x, y, z int, bord bool, m *TileMap) *MapView {
+	mv := &MapView{
+		TileView:   *NewTileView(w, h, x, y, z, bord),
+		Camera:     NewCamera(w, h),
+		tileMap:    m,
+		FOV:        true,
+		MemoryTint: MakeOpaqueColour(96, 96, 96),
+		drawn:      make([]Visuals, w*h),
+		valid:      make([]bool, w*h),
+	}
+
+	return mv
+}
+
+//Changes the map being viewed. Forces a full redraw.
+func (mv *MapView) SetMap(m *TileMap) {
+	mv.tileMap = m
+	mv.ForceRedraw()
+}
+
+//Draws the map from the point of view of a particular observer, using their FOV and memory instead of
+//the visibility info stored in the map. Pass nil to go back to using the map.
+func (mv *MapView) SetVision(v *Vision) {
+	mv.vision = v
+}
+
+//Marks the whole view for redrawing next time DrawMap() is called.
+func (mv *MapView) ForceRedraw() {
+	for i := range mv.valid {
+		mv.valid[i] = false
+	}
+}
+
+//Moves the camera to follow the map coordinate (x, y), respecting the dead zone and keeping the
+//camera within the map.
+func (mv *MapView) Follow(x, y int) {
+	mv.Camera.Follow(x, y)
+	if mv.tileMap != nil {
+		mv.Camera.Clamp(mv.tileMap.Dims())
+	}
+}
+
+//Centers the camera on map coordinate (x, y), keeping the camera within the map.
+func (mv *MapView) CenterOn(x, y int) {
+	mv.Camera.CenterOn(x, y)
+	if mv.tileMap != nil {
+		mv.Camera.Clamp(mv.tileMap.Dims())
+	}
+}
+
+//Converts console coordinates to map coordinates. Handy for mouse stuff.
+func (mv *MapView) ScreenToMap(x, y int) (int, int) {
+	return mv.Camera.ViewToMap(x-mv.x, y-mv.y)
+}
+
+//Converts map coordinates to console coordinates.
+func (mv *MapView) MapToScreen(x, y int) (int, int) {
+	vx, vy := mv.Camera.MapToView(x, y)
+	return vx + mv.x, vy + mv.y
+}
+
+//Draws the part of the map under the camera to the view. tick is the current tick, used to decide
+//which tiles are currently visible when FOV is on. Only cells that have changed since the last
+//call are touched.
+func (mv *MapView) DrawMap(tick int) {
+	if mv.tileMap == nil {
+		return
+	}
+
+	for i := range mv.grid {
+		vx, vy := i%mv.width, i/mv.width
+		x, y := mv.Camera.ViewToMap(vx, vy)
+		v := mv.tileVisuals(x, y, tick)
+
+		if mv.valid[i] && mv.drawn[i] == v {
+			continue
+		}
+
+		mv.Draw(vx, vy, v.Glyph, v.ForeColour, v.BackColour)
+		mv.drawn[i] = v
+		mv.valid[i] = true
+	}
+}
+
+//Computes the visuals for the map tile at (x, y).
+func (mv *MapView) tileVisuals(x, y, tick int) (v Visuals) {
+	if !CheckBounds(x, y, mv.tileMap.Width, mv.tileMap.Height) {
+		return Visuals{GLYPH_NONE, COL_BLACK, COL_BLACK}
+	}
+
+	t := mv.tileMap.GetTile(x, y)
+
+	var visible, remembered bool
+	var memory Visuals
+	switch {
+	case !mv.FOV:
+		visible = true
+	case mv.vision != nil:
+		visible = mv.vision.CanSee(x, y)
+		memory, remembered = mv.vision.GetMemory(x, y)
+	default:
+		visible = t.LastVisible == tick
+		memory, remembered = t.GetVisuals(), t.LastVisible != 0
+	}
+
+	if visible {
+		v = mv.statusOverlay(t.TopDrawable(), t.GetCompositeVisuals(), tick)
+		if mv.UseLighting {
+			v = ApplyLight(v, mv.tileMap.GetLight(x, y))
+		}
+	} else if remembered {
+		v = memory
+		v.ForeColour = BlendColours(v.ForeColour, mv.MemoryTint, BLEND_MULTIPLY)
+		v.BackColour = BlendColours(v.BackColour, mv.MemoryTint, BLEND_MULTIPLY)
+	} else {
+		v = Visuals{GLYPH_NONE, COL_BLACK, COL_BLACK}
+	}
+
+	return
+}
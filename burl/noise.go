@@ -0,0 +1,345 @@
+package burl
+
+import (
+	"math"
+	"math/rand"
+)
+
+//Noise is a source of smooth, seeded random values, for terrain, clouds, starfields and the like.
+//Values are in the range [-1, 1] (some kinds of noise rarely get all the way to the ends). The same
+//seed and coordinates always give the same value. Noise sources can be layered with FBM and
+//DomainWarp, which are Noise themselves.
+type Noise interface {
+	Noise2D(x, y float64) float64
+	Noise3D(x, y, z float64) float64
+}
+
+//Permutation table shared by all the lattice noise types. Doubled up to avoid wrapping indices.
+type noisePerm [512]int
+
+func newNoisePerm(seed int64) (p noisePerm) {
+	for i, v := range rand.New(rand.NewSource(seed)).Perm(256) {
+		p[i], p[i+256] = v, v
+	}
+	return
+}
+
+//Hashes lattice coordinates down to 0-255.
+func (p *noisePerm) hash2(x, y int) int {
+	return p[p[x&255]+y&255]
+}
+
+func (p *noisePerm) hash3(x, y, z int) int {
+	return p[p[p[x&255]+y&255]+z&255]
+}
+
+//The smootherstep curve 6t^5 - 15t^4 + 10t^3. Gets rid of the grid artifacts of linear interpolation.
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerpFloat(a, b, t float64) float64 {
+	return a + t*(b-a)
+}
+
+//ValueNoise interpolates between random values placed at each integer coordinate. Cheap, and blobbier
+//than gradient noise.
+type ValueNoise struct {
+	perm   noisePerm
+	values [256]float64
+}
+
+func NewValueNoise(seed int64) *ValueNoise {
+	vn := &ValueNoise{perm: newNoisePerm(seed)}
+	r := rand.New(rand.NewSource(seed + 1))
+	for i := range vn.values {
+		vn.values[i] = r.Float64()*2 - 1
+	}
+	return vn
+}
+
+func (vn *ValueNoise) Noise2D(x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	ix, iy := int(x0), int(y0)
+	u, v := fade(x-x0), fade(y-y0)
+
+	return lerpFloat(
+		lerpFloat(vn.values[vn.perm.hash2(ix, iy)], vn.values[vn.perm.hash2(ix+1, iy)], u),
+		lerpFloat(vn.values[vn.perm.hash2(ix, iy+1)], vn.values[vn.perm.hash2(ix+1, iy+1)], u),
+		v)
+}
+
+func (vn *ValueNoise) Noise3D(x, y, z float64) float64 {
+	x0, y0, z0 := math.Floor(x), math.Floor(y), math.Floor(z)
+	ix, iy, iz := int(x0), int(y0), int(z0)
+	u, v, w := fade(x-x0), fade(y-y0), fade(z-z0)
+
+	c := func(dx, dy, dz int) float64 {
+		return vn.values[vn.perm.hash3(ix+dx, iy+dy, iz+dz)]
+	}
+
+	return lerpFloat(
+		lerpFloat(lerpFloat(c(0, 0, 0), c(1, 0, 0), u), lerpFloat(c(0, 1, 0), c(1, 1, 0), u), v),
+		lerpFloat(lerpFloat(c(0, 0, 1), c(1, 0, 1), u), lerpFloat(c(0, 1, 1), c(1, 1, 1), u), v),
+		w)
+}
+
+//PerlinNoise is Ken Perlin's improved gradient noise. The classic.
+type PerlinNoise struct {
+	perm noisePerm
+}
+
+func NewPerlinNoise(seed int64) *PerlinNoise {
+	return &PerlinNoise{newNoisePerm(seed)}
+}
+
+//gradients for 2D perlin noise: 8 directions around the unit square.
+var grad2 = [8][2]float64{{1, 1}, {-1, 1}, {1, -1}, {-1, -1}, {1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+//gradients for 3D noise: the midpoints of the edges of a cube.
+var grad3 = [12][3]float64{
+	{1, 1, 0}, {-1, 1, 0}, {1, -1, 0}, {-1, -1, 0},
+	{1, 0, 1}, {-1, 0, 1}, {1, 0, -1}, {-1, 0, -1},
+	{0, 1, 1}, {0, -1, 1}, {0, 1, -1}, {0, -1, -1},
+}
+
+func (pn *PerlinNoise) Noise2D(x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	ix, iy := int(x0), int(y0)
+	fx, fy := x-x0, y-y0
+	u, v := fade(fx), fade(fy)
+
+	g := func(dx, dy int) float64 {
+		gr := grad2[pn.perm.hash2(ix+dx, iy+dy)&7]
+		return gr[0]*(fx-float64(dx)) + gr[1]*(fy-float64(dy))
+	}
+
+	return lerpFloat(lerpFloat(g(0, 0), g(1, 0), u), lerpFloat(g(0, 1), g(1, 1), u), v)
+}
+
+func (pn *PerlinNoise) Noise3D(x, y, z float64) float64 {
+	x0, y0, z0 := math.Floor(x), math.Floor(y), math.Floor(z)
+	ix, iy, iz := int(x0), int(y0), int(z0)
+	fx, fy, fz := x-x0, y-y0, z-z0
+	u, v, w := fade(fx), fade(fy), fade(fz)
+
+	g := func(dx, dy, dz int) float64 {
+		gr := grad3[pn.perm.hash3(ix+dx, iy+dy, iz+dz)%12]
+		return gr[0]*(fx-float64(dx)) + gr[1]*(fy-float64(dy)) + gr[2]*(fz-float64(dz))
+	}
+
+	return lerpFloat(
+		lerpFloat(lerpFloat(g(0, 0, 0), g(1, 0, 0), u), lerpFloat(g(0, 1, 0), g(1, 1, 0), u), v),
+		lerpFloat(lerpFloat(g(0, 0, 1), g(1, 0, 1), u), lerpFloat(g(0, 1, 1), g(1, 1, 1), u), v),
+		w)
+}
+
+//SimplexNoise is gradient noise over a grid of triangles (2D) or tetrahedra (3D) instead of squares.
+//Fewer directional artifacts than Perlin noise, and faster in 3D. Based on Stefan Gustavson's paper
+//"Simplex noise demystified".
+type SimplexNoise struct {
+	perm noisePerm
+}
+
+func NewSimplexNoise(seed int64) *SimplexNoise {
+	return &SimplexNoise{newNoisePerm(seed)}
+}
+
+var (
+	simplexF2 = 0.5 * (math.Sqrt(3) - 1)
+	simplexG2 = (3 - math.Sqrt(3)) / 6
+)
+
+const (
+	simplexF3 = 1.0 / 3
+	simplexG3 = 1.0 / 6
+)
+
+func (sn *SimplexNoise) Noise2D(x, y float64) float64 {
+	//skew to find which simplex cell we're in
+	s := (x + y) * simplexF2
+	i, j := math.Floor(x+s), math.Floor(y+s)
+	t := (i + j) * simplexG2
+	x0, y0 := x-(i-t), y-(j-t)
+
+	//which triangle of the cell?
+	i1, j1 := 0, 1
+	if x0 > y0 {
+		i1, j1 = 1, 0
+	}
+
+	x1, y1 := x0-float64(i1)+simplexG2, y0-float64(j1)+simplexG2
+	x2, y2 := x0-1+2*simplexG2, y0-1+2*simplexG2
+	ii, jj := int(i), int(j)
+
+	corner := func(h int, x, y float64) float64 {
+		t := 0.5 - x*x - y*y
+		if t < 0 {
+			return 0
+		}
+		t *= t
+		g := grad3[h%12]
+		return t * t * (g[0]*x + g[1]*y)
+	}
+
+	n := corner(sn.perm.hash2(ii, jj), x0, y0) +
+		corner(sn.perm.hash2(ii+i1, jj+j1), x1, y1) +
+		corner(sn.perm.hash2(ii+1, jj+1), x2, y2)
+
+	return 70 * n
+}
+
+func (sn *SimplexNoise) Noise3D(x, y, z float64) float64 {
+	s := (x + y + z) * simplexF3
+	i, j, k := math.Floor(x+s), math.Floor(y+s), math.Floor(z+s)
+	t := (i + j + k) * simplexG3
+	x0, y0, z0 := x-(i-t), y-(j-t), z-(k-t)
+
+	//which of the 6 tetrahedra of the cell are we in?
+	var i1, j1, k1, i2, j2, k2 int
+	switch {
+	case x0 >= y0 && y0 >= z0:
+		i1, j1, k1, i2, j2, k2 = 1, 0, 0, 1, 1, 0
+	case x0 >= y0 && x0 >= z0:
+		i1, j1, k1, i2, j2, k2 = 1, 0, 0, 1, 0, 1
+	case x0 >= y0:
+		i1, j1, k1, i2, j2, k2 = 0, 0, 1, 1, 0, 1
+	case y0 < z0:
+		i1, j1, k1, i2, j2, k2 = 0, 0, 1, 0, 1, 1
+	case x0 < z0:
+		i1, j1, k1, i2, j2, k2 = 0, 1, 0, 0, 1, 1
+	default:
+		i1, j1, k1, i2, j2, k2 = 0, 1, 0, 1, 1, 0
+	}
+
+	x1, y1, z1 := x0-float64(i1)+simplexG3, y0-float64(j1)+simplexG3, z0-float64(k1)+simplexG3
+	x2, y2, z2 := x0-float64(i2)+2*simplexG3, y0-float64(j2)+2*simplexG3, z0-float64(k2)+2*simplexG3
+	x3, y3, z3 := x0-1+3*simplexG3, y0-1+3*simplexG3, z0-1+3*simplexG3
+	ii, jj, kk := int(i), int(j), int(k)
+
+	corner := func(h int, x, y, z float64) float64 {
+		t := 0.6 - x*x - y*y - z*z
+		if t < 0 {
+			return 0
+		}
+		t *= t
+		g := grad3[h%12]
+		return t * t * (g[0]*x + g[1]*y + g[2]*z)
+	}
+
+	n := corner(sn.perm.hash3(ii, jj, kk), x0, y0, z0) +
+		corner(sn.perm.hash3(ii+i1, jj+j1, kk+k1), x1, y1, z1) +
+		corner(sn.perm.hash3(ii+i2, jj+j2, kk+k2), x2, y2, z2) +
+		corner(sn.perm.hash3(ii+1, jj+1, kk+1), x3, y3, z3)
+
+	return 32 * n
+}
+
+//FBM (fractal Brownian motion) layers several octaves of a noise source, each at a higher frequency
+//and lower amplitude than the last. Adds detail at every scale: coastlines, mountains, clouds.
+type FBM struct {
+	Source     Noise
+	Octaves    int
+	Lacunarity float64 //frequency multiplier for each octave
+	Gain       float64 //amplitude multiplier for each octave
+}
+
+//Creates an FBM with the usual settings: lacunarity 2, gain 0.5.
+func NewFBM(source Noise, octaves int) *FBM {
+	return &FBM{source, octaves, 2, 0.5}
+}
+
+func (f *FBM) Noise2D(x, y float64) float64 {
+	return f.sum(func(freq float64) float64 { return f.Source.Noise2D(x*freq, y*freq) })
+}
+
+func (f *FBM) Noise3D(x, y, z float64) float64 {
+	return f.sum(func(freq float64) float64 { return f.Source.Noise3D(x*freq, y*freq, z*freq) })
+}
+
+//Adds up the octaves, normalized so the result stays in [-1, 1].
+func (f *FBM) sum(octave func(freq float64) float64) float64 {
+	total, amp, freq, norm := 0.0, 1.0, 1.0, 0.0
+	for i := 0; i < Max(f.Octaves, 1); i++ {
+		total += octave(freq) * amp
+		norm += amp
+		amp *= f.Gain
+		freq *= f.Lacunarity
+	}
+	return total / norm
+}
+
+//DomainWarp distorts a noise source by offsetting the coordinates with another noise source before
+//sampling. Turns blobby noise into swirly, eroded-looking noise.
+type DomainWarp struct {
+	Source   Noise
+	Warp     Noise
+	Strength float64 //how far coordinates get pushed around
+}
+
+func NewDomainWarp(source, warp Noise, strength float64) *DomainWarp {
+	return &DomainWarp{source, warp, strength}
+}
+
+//the warp is sampled at different offsets for each axis so they don't all move together
+func (dw *DomainWarp) Noise2D(x, y float64) float64 {
+	wx := dw.Warp.Noise2D(x, y)
+	wy := dw.Warp.Noise2D(x+5.2, y+1.3)
+	return dw.Source.Noise2D(x+dw.Strength*wx, y+dw.Strength*wy)
+}
+
+func (dw *DomainWarp) Noise3D(x, y, z float64) float64 {
+	wx := dw.Warp.Noise3D(x, y, z)
+	wy := dw.Warp.Noise3D(x+5.2, y+1.3, z+2.8)
+	wz := dw.Warp.Noise3D(x+9.7, y+3.1, z+7.4)
+	return dw.Source.Noise3D(x+dw.Strength*wx, y+dw.Strength*wy, z+dw.Strength*wz)
+}
+
+//Samples 2D noise over a w x h grid. scale is the size of one grid cell in noise space, so smaller
+//scales give smoother results. Values are stored row by row.
+func NoiseGrid(n Noise, w, h int, scale float64) []float64 {
+	grid := make([]float64, w*h)
+	for i := range grid {
+		grid[i] = n.Noise2D(float64(i%w)*scale, float64(i/w)*scale)
+	}
+	return grid
+}
+
+//Picks a colour from the palette for a noise value in [-1, 1]. -1 gives the first colour, 1 the last.
+func NoiseColour(v float64, p Palette) uint32 {
+	if len(p) == 0 {
+		return COL_NONE
+	}
+	i := int((v + 1) / 2 * float64(len(p)))
+	return p[Clamp(i, 0, len(p)-1)]
+}
+
+//A range of noise values to turn into a tile type. See TileMap.ApplyNoise().
+type NoiseBand struct {
+	Max  float64 //values up to and including this get this band's tile
+	Tile int
+}
+
+//Sets the tile types of the map from noise: each tile gets the tile of the first band whose Max is
+//at least the noise value there. Bands should be in ascending order of Max. Values above every band
+//get the last band's tile. scale is as in NoiseGrid().
+//
+//	//water, sand, grass, mountains
+//	m.ApplyNoise(NewFBM(NewSimplexNoise(seed), 5), 0.05, []NoiseBand{{-0.2, water}, {-0.1, sand}, {0.4, grass}, {1, rock}})
+func (m *TileMap) ApplyNoise(n Noise, scale float64, bands []NoiseBand) {
+	if len(bands) == 0 {
+		return
+	}
+
+	for i := range m.Tiles {
+		v := n.Noise2D(float64(i%m.Width)*scale, float64(i/m.Width)*scale)
+		tile := bands[len(bands)-1].Tile
+		for _, b := range bands {
+			if v <= b.Max {
+				tile = b.Tile
+				break
+			}
+		}
+		m.ChangeTileType(i%m.Width, i/m.Width, tile)
+	}
+}
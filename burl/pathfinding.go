@@ -0,0 +1,396 @@
+package burl
+
+import (
+	"container/heap"
+	"math"
+)
+
+type MoveMode int
+
+const (
+	MOVE_4WAY MoveMode = iota //cardinal directions only
+	MOVE_8WAY                 //cardinals and diagonals
+)
+
+//Rules for moving diagonally past blocked tiles. Only matter for MOVE_8WAY.
+type CornerRule int
+
+const (
+	CORNER_ALLOW      CornerRule = iota //diagonal moves are always allowed
+	CORNER_NO_SQUEEZE                   //can't squeeze diagonally between two blocked tiles
+	CORNER_NO_CUT                       //can't move diagonally if either neighbouring cardinal tile is blocked
+)
+
+//PathCost reports the cost of entering the tile at (x, y). Return a negative number for tiles that
+//cannot be entered. Costs should be at least 1 or A* might not find the best path.
+type PathCost func(m *TileMap, x, y int) int
+
+//Default PathCost. Passable tiles cost their tile type's move cost (see GetMoveCost()), everything
+//else (walls, tiles with blocking entities) can't be entered.
+func PassableCost(m *TileMap, x, y int) int {
+	if t := m.GetTile(x, y); t.Passable() {
+		return GetMoveCost(t.TileType)
+	}
+	return -1
+}
+
+//PathCost that ignores entities and only considers the terrain. Good for dijkstra maps, where you
+//usually don't want monsters blocking each other's routes.
+func TerrainCost(m *TileMap, x, y int) int {
+	if t := m.GetTileType(x, y); IsPassable(t) {
+		return GetMoveCost(t)
+	}
+	return -1
+}
+
+//PathOptions control how paths are computed. The zero value gives 4-way movement over passable tiles.
+type PathOptions struct {
+	Movement MoveMode
+	Corners  CornerRule
+	Cost     PathCost //if nil, uses PassableCost
+}
+
+func (po PathOptions) cost(m *TileMap, x, y int) int {
+	if po.Cost == nil {
+		return PassableCost(m, x, y)
+	}
+	return po.Cost(m, x, y)
+}
+
+func (po PathOptions) directions() []Coord {
+	if po.Movement == MOVE_8WAY {
+		return dirs8
+	}
+	return dirs4
+}
+
+//Estimated cost from (x1, y1) to (x2, y2), assuming every tile costs 1.
+func (po PathOptions) heuristic(x1, y1, x2, y2 int) int {
+	if po.Movement == MOVE_8WAY {
+		return Max(Abs(x2-x1), Abs(y2-y1))
+	}
+	return ManhattanDistance(x1, y1, x2, y2)
+}
+
+//Checks the corner rules for a step from (x, y) in direction (dx, dy).
+func (po PathOptions) cornerAllowed(m *TileMap, x, y, dx, dy int) bool {
+	if dx == 0 || dy == 0 || po.Corners == CORNER_ALLOW {
+		return true
+	}
+
+	a, b := po.cost(m, x+dx, y) < 0, po.cost(m, x, y+dy) < 0
+	if po.Corners == CORNER_NO_SQUEEZE {
+		return !(a && b)
+	}
+	return !a && !b
+}
+
+var dirs4 = []Coord{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+var dirs8 = []Coord{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {-1, 1}, {1, -1}, {-1, -1}}
+
+//Finds the cheapest path from start to goal using A*. The returned path does not include start, but
+//does include goal. The goal can always be entered, even if it isn't passable (so you can path to the
+//player). Returns nil if there is no path.
+func (m *TileMap) FindPath(start, goal Coord, opts PathOptions) []Coord {
+	if !CheckBounds(start.X, start.Y, m.Width, m.Height) || !CheckBounds(goal.X, goal.Y, m.Width, m.Height) {
+		return nil
+	}
+
+	if start == goal {
+		return []Coord{}
+	}
+
+	g := make([]int, len(m.Tiles))
+	parent := make([]int, len(m.Tiles))
+	closed := make([]bool, len(m.Tiles))
+	for i := range g {
+		g[i] = -1
+	}
+
+	s, goalIndex := start.X+start.Y*m.Width, goal.X+goal.Y*m.Width
+	g[s] = 0
+	open := &pathQueue{{s, opts.heuristic(start.X, start.Y, goal.X, goal.Y)}}
+
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(pathNode)
+		if closed[cur.index] {
+			continue
+		}
+		closed[cur.index] = true
+
+		if cur.index == goalIndex {
+			return m.buildPath(parent, s, goalIndex)
+		}
+
+		x, y := cur.index%m.Width, cur.index/m.Width
+		for _, d := range opts.directions() {
+			nx, ny := x+d.X, y+d.Y
+			if !CheckBounds(nx, ny, m.Width, m.Height) || closed[nx+ny*m.Width] {
+				continue
+			}
+
+			n := nx + ny*m.Width
+			c := opts.cost(m, nx, ny)
+			if n == goalIndex && c < 0 {
+				c = 1
+			}
+			if c < 0 || !opts.cornerAllowed(m, x, y, d.X, d.Y) {
+				continue
+			}
+
+			if cost := g[cur.index] + c; g[n] == -1 || cost < g[n] {
+				g[n] = cost
+				parent[n] = cur.index
+				heap.Push(open, pathNode{n, cost + opts.heuristic(nx, ny, goal.X, goal.Y)})
+			}
+		}
+	}
+
+	return nil
+}
+
+//Walks back from the goal to the start building a path.
+func (m *TileMap) buildPath(parent []int, start, goal int) (path []Coord) {
+	for i := goal; i != start; i = parent[i] {
+		path = append(path, Coord{i % m.Width, i / m.Width})
+	}
+
+	//reverse, so path runs start -> goal
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return
+}
+
+//Value for tiles that can't be reached from any source in a DijkstraMap.
+const DIJKSTRA_UNREACHABLE int = math.MaxInt32
+
+//DijkstraMap holds, for every tile on a map, the cost of travelling there from the nearest source.
+//Sources can be given different starting values to make some more attractive than others. Monsters
+//can roll downhill (see NextStep()) to approach the sources, or use a FleeMap() to run away from
+//them. When the map changes, Update() recomputes only the parts of the map that are affected.
+type DijkstraMap struct {
+	tileMap *TileMap
+	opts    PathOptions
+	values  []int
+	sources map[int]int //index -> source value
+}
+
+func NewDijkstraMap(m *TileMap, opts PathOptions) *DijkstraMap {
+	dm := &DijkstraMap{
+		tileMap: m,
+		opts:    opts,
+		values:  make([]int, len(m.Tiles)),
+		sources: make(map[int]int),
+	}
+
+	for i := range dm.values {
+		dm.values[i] = DIJKSTRA_UNREACHABLE
+	}
+
+	return dm
+}
+
+//Adds a source at (x, y). Call Compute() or Update() afterwards to see the changes.
+func (dm *DijkstraMap) AddSource(x, y, value int) {
+	if CheckBounds(x, y, dm.tileMap.Width, dm.tileMap.Height) {
+		dm.sources[x+y*dm.tileMap.Width] = value
+	}
+}
+
+//Removes the source at (x, y). Call Compute() or Update() afterwards to see the changes.
+func (dm *DijkstraMap) RemoveSource(x, y int) {
+	delete(dm.sources, x+y*dm.tileMap.Width)
+}
+
+func (dm *DijkstraMap) ClearSources() {
+	dm.sources = make(map[int]int)
+}
+
+//Returns the value at (x, y). Returns DIJKSTRA_UNREACHABLE for tiles that can't be reached, or are
+//out of bounds.
+func (dm *DijkstraMap) Get(x, y int) int {
+	if CheckBounds(x, y, dm.tileMap.Width, dm.tileMap.Height) {
+		return dm.values[x+y*dm.tileMap.Width]
+	}
+	return DIJKSTRA_UNREACHABLE
+}
+
+//Recomputes the whole map from scratch.
+func (dm *DijkstraMap) Compute() {
+	open := &pathQueue{}
+	for i := range dm.values {
+		dm.values[i] = DIJKSTRA_UNREACHABLE
+	}
+
+	for i, v := range dm.sources {
+		dm.values[i] = v
+		heap.Push(open, pathNode{i, v})
+	}
+
+	dm.relax(open)
+}
+
+//Recomputes the map after the tiles at the provided coords have changed (a door was closed, a wall dug
+//out, a monster moved, etc). Sources added or removed since the last computation should also be
+//passed in here. Only the parts of the map that depended on the changed tiles are recomputed.
+func (dm *DijkstraMap) Update(changed ...Coord) {
+	w, h := dm.tileMap.Dims()
+
+	//changing a tile can change which diagonal moves are allowed around it, so neighbours are dirty too
+	dirty := make(map[int]bool)
+	queue := make([]int, 0)
+	for _, c := range changed {
+		for i := 0; i < 9; i++ {
+			if x, y := c.X+i%3-1, c.Y+i/3-1; CheckBounds(x, y, w, h) && !dirty[x+y*w] {
+				dirty[x+y*w] = true
+				queue = append(queue, x+y*w)
+			}
+		}
+	}
+
+	//find all the tiles whose values came through a dirty tile
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if dm.values[i] == DIJKSTRA_UNREACHABLE {
+			continue
+		}
+
+		x, y := i%w, i/w
+		for _, d := range dm.opts.directions() {
+			nx, ny := x+d.X, y+d.Y
+			n := nx + ny*w
+			if !CheckBounds(nx, ny, w, h) || dirty[n] || dm.values[n] == DIJKSTRA_UNREACHABLE {
+				continue
+			}
+
+			if c := dm.opts.cost(dm.tileMap, nx, ny); c >= 0 && dm.values[n] == dm.values[i]+c {
+				dirty[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	for i := range dirty {
+		dm.values[i] = DIJKSTRA_UNREACHABLE
+	}
+
+	//reseed the dirty region from its clean edges (and any sources inside it), then let it flow.
+	open := &pathQueue{}
+	for i := range dirty {
+		if v, ok := dm.sources[i]; ok {
+			dm.values[i] = v
+		}
+
+		x, y := i%w, i/w
+		c := dm.opts.cost(dm.tileMap, x, y)
+		if c < 0 {
+			if dm.values[i] != DIJKSTRA_UNREACHABLE {
+				heap.Push(open, pathNode{i, dm.values[i]})
+			}
+			continue
+		}
+
+		for _, d := range dm.opts.directions() {
+			nx, ny := x+d.X, y+d.Y
+			n := nx + ny*w
+			if CheckBounds(nx, ny, w, h) && !dirty[n] && dm.values[n] != DIJKSTRA_UNREACHABLE && dm.opts.cornerAllowed(dm.tileMap, nx, ny, -d.X, -d.Y) {
+				dm.values[i] = Min(dm.values[i], dm.values[n]+c)
+			}
+		}
+
+		if dm.values[i] != DIJKSTRA_UNREACHABLE {
+			heap.Push(open, pathNode{i, dm.values[i]})
+		}
+	}
+
+	//sources outside the dirty region might have been added since the last computation
+	for i, v := range dm.sources {
+		if !dirty[i] && v < dm.values[i] {
+			dm.values[i] = v
+			heap.Push(open, pathNode{i, v})
+		}
+	}
+
+	dm.relax(open)
+}
+
+//Runs dijkstra's algorithm outwards from the nodes in the queue.
+func (dm *DijkstraMap) relax(open *pathQueue) {
+	w, h := dm.tileMap.Dims()
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(pathNode)
+		if cur.priority > dm.values[cur.index] {
+			continue //stale
+		}
+
+		x, y := cur.index%w, cur.index/w
+		for _, d := range dm.opts.directions() {
+			nx, ny := x+d.X, y+d.Y
+			if !CheckBounds(nx, ny, w, h) {
+				continue
+			}
+
+			n := nx + ny*w
+			c := dm.opts.cost(dm.tileMap, nx, ny)
+			if c < 0 || !dm.opts.cornerAllowed(dm.tileMap, x, y, d.X, d.Y) {
+				continue
+			}
+
+			if v := cur.priority + c; v < dm.values[n] {
+				dm.values[n] = v
+				heap.Push(open, pathNode{n, v})
+			}
+		}
+	}
+}
+
+//Returns the neighbouring tile with the lowest value, ie. the next step towards the nearest source.
+//If no neighbour is lower than (x, y) (you're at a source, or stuck), returns false.
+func (dm *DijkstraMap) NextStep(x, y int) (Coord, bool) {
+	best, bestVal := Coord{x, y}, dm.Get(x, y)
+	for _, d := range dm.opts.directions() {
+		if v := dm.Get(x+d.X, y+d.Y); v < bestVal && dm.opts.cornerAllowed(dm.tileMap, x, y, d.X, d.Y) {
+			best, bestVal = Coord{x + d.X, y + d.Y}, v
+		}
+	}
+
+	return best, best != Coord{x, y}
+}
+
+//Creates a map for running away from the sources of this one. Every reachable tile becomes a source
+//with its value multiplied by coef, which should be negative. Around -1.2 works well: rolling
+//downhill on the result leads away from the sources, but prefers to escape towards open areas
+//rather than getting cornered. Compute() is run on the new map before it is returned.
+func (dm *DijkstraMap) FleeMap(coef float64) *DijkstraMap {
+	flee := NewDijkstraMap(dm.tileMap, dm.opts)
+	for i, v := range dm.values {
+		if v != DIJKSTRA_UNREACHABLE {
+			flee.sources[i] = RoundFloatToInt(float64(v) * coef)
+		}
+	}
+	flee.Compute()
+
+	return flee
+}
+
+//Priority queue for pathfinding. Lowest priority comes out first.
+type pathNode struct {
+	index, priority int
+}
+
+type pathQueue []pathNode
+
+func (pq pathQueue) Len() int            { return len(pq) }
+func (pq pathQueue) Less(i, j int) bool  { return pq[i].priority < pq[j].priority }
+func (pq pathQueue) Swap(i, j int)       { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *pathQueue) Push(x interface{}) { *pq = append(*pq, x.(pathNode)) }
+
+func (pq *pathQueue) Pop() interface{} {
+	old := *pq
+	n := old[len(old)-1]
+	*pq = old[:len(old)-1]
+	return n
+}
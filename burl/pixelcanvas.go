@@ -0,0 +1,158 @@
+package burl
+
+type PixelMode int
+
+const (
+	PIXEL_HALFBLOCK PixelMode = iota //each cell is 1x2 pixels, drawn exactly with the half-block glyph.
+	PIXEL_QUADRANT                   //each cell is 2x2 pixels, approximated with half-block glyphs.
+)
+
+//PixelCanvas is a drawing surface over a TileView that splits each cell into smaller "pixels", doubling
+//the resolution in one or both directions. For each cell, a glyph and fore/back colour pair is chosen
+//to best represent the pixels it contains. Only the half-block glyphs from the standard CP437 set are
+//used, so in PIXEL_QUADRANT mode some patterns (diagonals, 3-and-1 splits) are approximated.
+type PixelCanvas struct {
+	view          *TileView
+	mode          PixelMode
+	width, height int //dimensions in pixels
+	pixels        []uint32
+}
+
+//Creates a PixelCanvas that draws to the TileView. All pixels start black.
+func (tv *TileView) NewPixelCanvas(mode PixelMode) *PixelCanvas {
+	pc := &PixelCanvas{view: tv, mode: mode}
+	pc.width, pc.height = tv.width, tv.height*2
+	if mode == PIXEL_QUADRANT {
+		pc.width *= 2
+	}
+	pc.pixels = make([]uint32, pc.width*pc.height)
+	pc.Clear(COL_BLACK)
+
+	return pc
+}
+
+//Returns the dimensions of the canvas in pixels.
+func (pc *PixelCanvas) Dims() (int, int) {
+	return pc.width, pc.height
+}
+
+//Sets the pixel at (x, y) to colour c, and redraws the cell containing it.
+func (pc *PixelCanvas) Set(x, y int, c uint32) {
+	if CheckBounds(x, y, pc.width, pc.height) && pc.pixels[y*pc.width+x] != c {
+		pc.pixels[y*pc.width+x] = c
+		if pc.mode == PIXEL_QUADRANT {
+			pc.updateCell(x/2, y/2)
+		} else {
+			pc.updateCell(x, y/2)
+		}
+	}
+}
+
+//Returns the colour of the pixel at (x, y). Returns COL_NONE if out of bounds.
+func (pc *PixelCanvas) Get(x, y int) uint32 {
+	if CheckBounds(x, y, pc.width, pc.height) {
+		return pc.pixels[y*pc.width+x]
+	}
+	return COL_NONE
+}
+
+//Fills the whole canvas with colour c.
+func (pc *PixelCanvas) Clear(c uint32) {
+	for i := range pc.pixels {
+		pc.pixels[i] = c
+	}
+
+	for i := 0; i < pc.view.width*pc.view.height; i++ {
+		pc.updateCell(i%pc.view.width, i/pc.view.width)
+	}
+}
+
+//Chooses a glyph and colours for the cell at (x, y) and draws it to the TileView.
+func (pc *PixelCanvas) updateCell(x, y int) {
+	if pc.mode == PIXEL_HALFBLOCK {
+		top, bottom := pc.pixels[2*y*pc.width+x], pc.pixels[(2*y+1)*pc.width+x]
+		if top == bottom {
+			pc.view.Draw(x, y, GLYPH_NONE, top, bottom)
+		} else {
+			pc.view.Draw(x, y, GLYPH_HALFBLOCK_UP, top, bottom)
+		}
+		return
+	}
+
+	//pixels in order top-left, top-right, bottom-left, bottom-right
+	p := [4]uint32{
+		pc.pixels[2*y*pc.width+2*x],
+		pc.pixels[2*y*pc.width+2*x+1],
+		pc.pixels[(2*y+1)*pc.width+2*x],
+		pc.pixels[(2*y+1)*pc.width+2*x+1],
+	}
+
+	bestGlyph, bestFore, bestBack, bestErr := GLYPH_NONE, COL_NONE, COL_NONE, -1
+	for _, q := range quadrantGlyphs {
+		var fore, back []uint32
+		for i := range p {
+			if q.mask&(1<<uint(i)) != 0 {
+				fore = append(fore, p[i])
+			} else {
+				back = append(back, p[i])
+			}
+		}
+
+		f, b := averageColour(fore...), averageColour(back...)
+		err := 0
+		for i := range p {
+			if q.mask&(1<<uint(i)) != 0 {
+				err += colourDistance(p[i], f)
+			} else {
+				err += colourDistance(p[i], b)
+			}
+		}
+
+		if bestErr == -1 || err < bestErr {
+			bestGlyph, bestFore, bestBack, bestErr = q.glyph, f, b, err
+		}
+	}
+
+	if bestFore == COL_NONE {
+		bestFore = bestBack
+	}
+	pc.view.Draw(x, y, bestGlyph, bestFore, bestBack)
+}
+
+//glyphs usable for quadrant drawing, and the quadrants they fill in with the foreground colour.
+//mask bits are top-left, top-right, bottom-left, bottom-right from least to most significant.
+var quadrantGlyphs = []struct {
+	glyph int
+	mask  uint
+}{
+	{GLYPH_NONE, 0x0},
+	{GLYPH_HALFBLOCK_UP, 0x3},
+	{GLYPH_HALFBLOCK_DOWN, 0xC},
+	{GLYPH_HALFBLOCK_LEFT, 0x5},
+	{GLYPH_HALFBLOCK_RIGHT, 0xA},
+}
+
+//Averages the RGB components of the provided colours. Result is opaque. Returns COL_NONE if no
+//colours are provided.
+func averageColour(cols ...uint32) uint32 {
+	if len(cols) == 0 {
+		return COL_NONE
+	}
+
+	var r, g, b int
+	for _, c := range cols {
+		cr, cg, cb, _ := GetRGBA(c)
+		r, g, b = r+int(cr), g+int(cg), b+int(cb)
+	}
+
+	return MakeOpaqueColour(r/len(cols), g/len(cols), b/len(cols))
+}
+
+//Squared distance between two colours in RGB space.
+func colourDistance(c1, c2 uint32) int {
+	r1, g1, b1, _ := GetRGBA(c1)
+	r2, g2, b2, _ := GetRGBA(c2)
+	dr, dg, db := int(r1)-int(r2), int(g1)-int(g2), int(b1)-int(b2)
+
+	return dr*dr + dg*dg + db*db
+}
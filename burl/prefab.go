@@ -0,0 +1,248 @@
+package burl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bennicholls/burl-E/reximage"
+)
+
+//A Prefab is a hand-made chunk of map (a room, a vault, a shrine) that can be stamped into a TileMap.
+//Tiles set to -1 are left alone when stamping, so prefabs don't have to be rectangular. Markers are
+//named spots in the prefab for the game to spawn things at (monsters, treasure, the vault's boss).
+type Prefab struct {
+	Width, Height int
+	Tiles         []int
+	Markers       []PrefabMarker
+}
+
+type PrefabMarker struct {
+	Name string
+	X, Y int
+}
+
+//Builds a prefab from rows of text. Each rune is looked up in the legend to get a tile type. Runes
+//not in the legend become -1 (leave the map alone). Rows can be different lengths, the prefab is as
+//wide as the longest one.
+func NewPrefab(rows []string, legend map[rune]int) *Prefab {
+	p := &Prefab{Height: len(rows)}
+	for _, row := range rows {
+		p.Width = Max(p.Width, len([]rune(row)))
+	}
+
+	p.Tiles = make([]int, p.Width*p.Height)
+	for i := range p.Tiles {
+		p.Tiles[i] = -1
+	}
+
+	for y, row := range rows {
+		for x, r := range []rune(row) {
+			if t, ok := legend[r]; ok {
+				p.Tiles[x+y*p.Width] = t
+			}
+		}
+	}
+
+	return p
+}
+
+//Returns the tile type at (x, y) in the prefab, or -1 if out of bounds.
+func (p *Prefab) Get(x, y int) int {
+	if CheckBounds(x, y, p.Width, p.Height) {
+		return p.Tiles[x+y*p.Width]
+	}
+	return -1
+}
+
+//Returns a copy of the prefab rotated clockwise by 90 degrees (turns) times. Negative turns rotate
+//anticlockwise.
+func (p *Prefab) Rotate(turns int) *Prefab {
+	turns = ((turns % 4) + 4) % 4
+	r := p.copy()
+	for ; turns > 0; turns-- {
+		r = r.rotateOnce()
+	}
+	return r
+}
+
+func (p *Prefab) rotateOnce() *Prefab {
+	r := &Prefab{Width: p.Height, Height: p.Width, Tiles: make([]int, len(p.Tiles))}
+	for i, t := range p.Tiles {
+		x, y := i%p.Width, i/p.Width
+		r.Tiles[(p.Height-1-y)+x*r.Width] = t
+	}
+	for _, mk := range p.Markers {
+		r.Markers = append(r.Markers, PrefabMarker{mk.Name, p.Height - 1 - mk.Y, mk.X})
+	}
+	return r
+}
+
+//Returns a copy of the prefab flipped left-to-right. Combine with Rotate() to get all 8 orientations.
+func (p *Prefab) Mirror() *Prefab {
+	r := &Prefab{Width: p.Width, Height: p.Height, Tiles: make([]int, len(p.Tiles))}
+	for i, t := range p.Tiles {
+		x, y := i%p.Width, i/p.Width
+		r.Tiles[(p.Width-1-x)+y*p.Width] = t
+	}
+	for _, mk := range p.Markers {
+		r.Markers = append(r.Markers, PrefabMarker{mk.Name, p.Width - 1 - mk.X, mk.Y})
+	}
+	return r
+}
+
+func (p *Prefab) copy() *Prefab {
+	return &Prefab{p.Width, p.Height, append([]int(nil), p.Tiles...), append([]PrefabMarker(nil), p.Markers...)}
+}
+
+//Stamps the prefab into the map with its top-left corner at (x, y). Parts that fall outside the map
+//are cut off. Returns the prefab's markers, moved to map coordinates.
+func (m *TileMap) Stamp(p *Prefab, x, y int) []PrefabMarker {
+	for i, t := range p.Tiles {
+		if t >= 0 {
+			m.ChangeTileType(x+i%p.Width, y+i/p.Width, t)
+		}
+	}
+
+	markers := make([]PrefabMarker, len(p.Markers))
+	for i, mk := range p.Markers {
+		markers[i] = PrefabMarker{mk.Name, mk.X + x, mk.Y + y}
+	}
+	return markers
+}
+
+//Reports whether the prefab can be stamped at (x, y) without trampling anything: every tile the prefab
+//sets must be on the map and have no entities or items on it. If canReplace isn't nil, it must also
+//return true for the tile type currently there (so you can keep vaults from being carved into existing
+//rooms, for example).
+func (m *TileMap) CanStamp(p *Prefab, x, y int, canReplace func(tileType int) bool) bool {
+	for i, t := range p.Tiles {
+		if t < 0 {
+			continue
+		}
+
+		mx, my := x+i%p.Width, y+i/p.Width
+		if !CheckBounds(mx, my, m.Width, m.Height) {
+			return false
+		}
+
+		tile := m.Tiles[mx+my*m.Width]
+		if tile.entity != nil || len(tile.entities) > 0 || len(tile.items) > 0 {
+			return false
+		}
+		if canReplace != nil && !canReplace(tile.TileType) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Stamps the prefab at (x, y) if CanStamp() says it's ok. Returns the markers and whether it was stamped.
+func (m *TileMap) TryStamp(p *Prefab, x, y int, canReplace func(tileType int) bool) ([]PrefabMarker, bool) {
+	if !m.CanStamp(p, x, y, canReplace) {
+		return nil, false
+	}
+	return m.Stamp(p, x, y), true
+}
+
+//XPKey identifies a glyph/colour combination drawn in REXPaint. COL_NONE colours match anything.
+type XPKey struct {
+	Glyph      int
+	ForeColour uint32
+	BackColour uint32
+}
+
+//Reports whether the key matches the cell. Returns how specific the match was, so that a key with
+//colours beats a key that matches any colour.
+func (k XPKey) match(glyph int, fore, back uint32) (bool, int) {
+	if k.Glyph != glyph || (k.ForeColour != COL_NONE && k.ForeColour != fore) || (k.BackColour != COL_NONE && k.BackColour != back) {
+		return false, 0
+	}
+
+	score := 0
+	if k.ForeColour != COL_NONE {
+		score++
+	}
+	if k.BackColour != COL_NONE {
+		score++
+	}
+	return true, score
+}
+
+//PrefabLegend says what the glyph/colour combinations in a REXPaint image mean: tile types, spawn
+//markers, or both. When several entries match a cell, the one with the most colours specified wins.
+type PrefabLegend struct {
+	entries []legendEntry
+}
+
+type legendEntry struct {
+	key    XPKey
+	tile   int    //-1 for whatever's underneath
+	marker string //"" for no marker
+}
+
+func NewPrefabLegend() *PrefabLegend {
+	return new(PrefabLegend)
+}
+
+//Cells matching k become tiles of type tile.
+func (pl *PrefabLegend) AddTile(k XPKey, tile int) {
+	pl.entries = append(pl.entries, legendEntry{k, tile, ""})
+}
+
+//Cells matching k become a marker called name, on a tile of type tile. Use -1 for tile to keep
+//whatever tile is under the marker on lower layers.
+func (pl *PrefabLegend) AddMarker(k XPKey, name string, tile int) {
+	pl.entries = append(pl.entries, legendEntry{k, tile, name})
+}
+
+func (pl *PrefabLegend) lookup(glyph int, fore, back uint32) (legendEntry, bool) {
+	best, bestScore, found := legendEntry{}, -1, false
+	for _, e := range pl.entries {
+		if ok, score := e.key.match(glyph, fore, back); ok && score > bestScore {
+			best, bestScore, found = e, score, true
+		}
+	}
+	return best, found
+}
+
+//Loads a prefab drawn in REXPaint, using the legend to turn cells into tiles and markers. Layers are
+//read lowest first, so markers can be drawn on a layer above the floor they sit on. Undrawn cells are
+//left as -1. Any drawn cell that isn't in the legend is an error, so typos don't silently vanish.
+func LoadPrefabFromXP(path string, legend *PrefabLegend) (*Prefab, error) {
+	layers, err := reximage.ImportLayers(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, errors.New("Prefab image " + path + " has no layers.")
+	}
+
+	p := &Prefab{Width: layers[0].Width, Height: layers[0].Height}
+	p.Tiles = make([]int, p.Width*p.Height)
+	for i := range p.Tiles {
+		p.Tiles[i] = -1
+	}
+
+	for l, layer := range layers {
+		for i, cell := range layer.Cells {
+			if cell.Undrawn() {
+				continue
+			}
+
+			fore, back := cell.ARGB()
+			e, ok := legend.lookup(int(cell.Glyph), fore, back)
+			if !ok {
+				return nil, fmt.Errorf("%s: layer %d, cell (%d, %d): glyph %d with colours %X/%X isn't in the legend", path, l, i%p.Width, i/p.Width, cell.Glyph, fore, back)
+			}
+			if e.tile >= 0 {
+				p.Tiles[i] = e.tile
+			}
+			if e.marker != "" {
+				p.Markers = append(p.Markers, PrefabMarker{e.marker, i % p.Width, i / p.Width})
+			}
+		}
+	}
+
+	return p, nil
+}
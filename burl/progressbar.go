@@ -6,10 +6,13 @@ type ProgressBar struct {
 	progressColour uint32
 	progress       int //percentage
 	barWidth       int
+
+	stat    *Stat //bound stat, see BindStat()
+	watchID int
 }
 
 func NewProgressBar(w, h, x, y, z int, bord, cent bool, txt string, c uint32) *ProgressBar {
-	return &ProgressBar{*NewTextbox(w, h, x, y, z, bord, cent, txt), c, 0, 0}
+	return &ProgressBar{*NewTextbox(w, h, x, y, z, bord, cent, txt), c, 0, 0, nil, 0}
 }
 
 //Takes a percentage value, clamped to 0 <= i <= 100
@@ -34,6 +37,20 @@ func (pb *ProgressBar) calcBarWidth() {
 	}
 }
 
+//Binds the progress bar to a stat, so its progress follows the stat's percentage automatically. Pass nil
+//to unbind.
+func (pb *ProgressBar) BindStat(s *Stat) {
+	if pb.stat != nil {
+		pb.stat.Unwatch(pb.watchID)
+	}
+
+	pb.stat = s
+	if s != nil {
+		pb.watchID = s.Watch(func(s *Stat) { pb.SetProgress(s.GetPct()) })
+		pb.SetProgress(s.GetPct())
+	}
+}
+
 func (pb *ProgressBar) GetProgress() int {
 	return pb.progress
 }
@@ -0,0 +1,318 @@
+package burl
+
+import (
+	"sort"
+)
+
+//Tools for checking what a map's layout actually looks like: which parts are connected, where the
+//rooms and corridors are, where the chokepoints and dead ends are. Handy for validating generated
+//maps, and for AI and spawning logic (guard the chokepoints, put treasure in the dead ends).
+//
+//Everything here takes a PathCost to decide what counts as passable (cost >= 0). If it's nil,
+//TerrainCost is used, so entities don't split regions. Connectivity is always 4-way.
+
+func regionCost(cost PathCost) PathCost {
+	if cost == nil {
+		return TerrainCost
+	}
+	return cost
+}
+
+//RegionMap labels every passable tile with the connected region it belongs to.
+type RegionMap struct {
+	Width, Height int
+	Regions       [][]Coord //the tiles in each region, biggest region first
+	labels        []int
+}
+
+//Splits the passable parts of the map into connected regions.
+func (m *TileMap) LabelRegions(cost PathCost) *RegionMap {
+	cost = regionCost(cost)
+	rm := &RegionMap{Width: m.Width, Height: m.Height, labels: make([]int, len(m.Tiles))}
+	rm.Regions = m.floodRegions(func(x, y int) bool { return cost(m, x, y) >= 0 })
+	sort.SliceStable(rm.Regions, func(i, j int) bool { return len(rm.Regions[i]) > len(rm.Regions[j]) })
+
+	for i := range rm.labels {
+		rm.labels[i] = -1
+	}
+	for r, region := range rm.Regions {
+		for _, c := range region {
+			rm.labels[c.X+c.Y*m.Width] = r
+		}
+	}
+
+	return rm
+}
+
+//Returns the region (x, y) is in, or -1 if it's impassable or off the map.
+func (rm *RegionMap) Get(x, y int) int {
+	if !CheckBounds(x, y, rm.Width, rm.Height) {
+		return -1
+	}
+	return rm.labels[x+y*rm.Width]
+}
+
+//Reports whether you can walk from a to b.
+func (rm *RegionMap) Connected(a, b Coord) bool {
+	r := rm.Get(a.X, a.Y)
+	return r != -1 && r == rm.Get(b.X, b.Y)
+}
+
+//Reports whether every passable tile on the map can reach every other one.
+func (m *TileMap) IsConnected(cost PathCost) bool {
+	return len(m.LabelRegions(cost).Regions) <= 1
+}
+
+//Returns the passable regions that can't be reached from start. If start isn't passable, that's
+//everything.
+func (m *TileMap) FindPockets(start Coord, cost PathCost) [][]Coord {
+	rm := m.LabelRegions(cost)
+	from := rm.Get(start.X, start.Y)
+
+	pockets := make([][]Coord, 0, len(rm.Regions))
+	for r, region := range rm.Regions {
+		if r != from {
+			pockets = append(pockets, region)
+		}
+	}
+	return pockets
+}
+
+//Digs the shortest possible tunnels (4-way) to join every region of the map into one, using tile for
+//the tunnels. Regions are joined to the biggest one, biggest first, each by the shortest route to
+//any region already joined. Tunnels never touch the edge of the map. Returns the tiles dug, and any
+//regions that couldn't be joined (because the only way to them is along the edge of the map).
+func (m *TileMap) ConnectRegions(tile int, cost PathCost) (dug []Coord, unconnected [][]Coord) {
+	rm := m.LabelRegions(cost)
+	if len(rm.Regions) <= 1 {
+		return
+	}
+
+	joined := make([]bool, len(rm.Regions))
+	joined[0] = true
+	for r := 1; r < len(rm.Regions); r++ {
+		if joined[r] {
+			continue //joined by a tunnel passing through it
+		}
+
+		tunnel, found := m.nearestRegion(rm.Regions[r], func(c Coord) bool {
+			t := rm.Get(c.X, c.Y)
+			return t >= 0 && t != r && joined[t]
+		})
+		if found == nil {
+			continue
+		}
+		for _, c := range tunnel {
+			if t := rm.Get(c.X, c.Y); t >= 0 {
+				joined[t] = true
+				continue
+			}
+			m.ChangeTileType(c.X, c.Y, tile)
+			dug = append(dug, c)
+		}
+		joined[r] = true
+	}
+
+	for r := range rm.Regions {
+		if !joined[r] {
+			unconnected = append(unconnected, rm.Regions[r])
+		}
+	}
+
+	return
+}
+
+//Returns the passable tiles with exactly one passable neighbour: the ends of corridors that don't go
+//anywhere.
+func (m *TileMap) FindDeadEnds(cost PathCost) (ends []Coord) {
+	cost = regionCost(cost)
+	for i := range m.Tiles {
+		x, y := i%m.Width, i/m.Width
+		if cost(m, x, y) < 0 {
+			continue
+		}
+
+		exits := 0
+		for _, d := range dirs4 {
+			if CheckBounds(x+d.X, y+d.Y, m.Width, m.Height) && cost(m, x+d.X, y+d.Y) >= 0 {
+				exits++
+			}
+		}
+		if exits == 1 {
+			ends = append(ends, Coord{x, y})
+		}
+	}
+
+	return
+}
+
+//Returns the chokepoints of the map: passable tiles that, if blocked, would split their region in two.
+//Every tile of a 1-wide corridor is a chokepoint, as is a door between two rooms. Returned in scan order.
+func (m *TileMap) FindChokepoints(cost PathCost) (chokes []Coord) {
+	cost = regionCost(cost)
+	n := len(m.Tiles)
+	passable := make([]bool, n)
+	for i := range passable {
+		passable[i] = cost(m, i%m.Width, i/m.Width) >= 0
+	}
+
+	//Tarjan's articulation points, done iteratively so huge open maps don't make for a huge stack
+	depth := make([]int, n) //order tiles were visited in, from 1. 0 = unvisited
+	low := make([]int, n)
+	parent := make([]int, n)
+	isChoke := make([]bool, n)
+
+	type frame struct{ i, dir, children int }
+	order := 0
+	for root := 0; root < n; root++ {
+		if !passable[root] || depth[root] != 0 {
+			continue
+		}
+
+		order++
+		depth[root], low[root], parent[root] = order, order, -1
+		stack := []frame{{root, 0, 0}}
+		for len(stack) > 0 {
+			f := &stack[len(stack)-1]
+			if f.dir < len(dirs4) {
+				d := dirs4[f.dir]
+				f.dir++
+				x, y := f.i%m.Width+d.X, f.i/m.Width+d.Y
+				if !CheckBounds(x, y, m.Width, m.Height) || !passable[x+y*m.Width] {
+					continue
+				}
+
+				j := x + y*m.Width
+				if depth[j] == 0 {
+					order++
+					depth[j], low[j], parent[j] = order, order, f.i
+					f.children++
+					stack = append(stack, frame{j, 0, 0})
+				} else if j != parent[f.i] {
+					low[f.i] = Min(low[f.i], depth[j])
+				}
+				continue
+			}
+
+			//done with this tile, report back to its parent
+			stack = stack[:len(stack)-1]
+			if p := parent[f.i]; p >= 0 {
+				low[p] = Min(low[p], low[f.i])
+				if parent[p] >= 0 && low[f.i] >= depth[p] {
+					isChoke[p] = true
+				}
+			} else if f.children > 1 {
+				isChoke[f.i] = true
+			}
+		}
+	}
+
+	for i, c := range isChoke {
+		if c {
+			chokes = append(chokes, Coord{i % m.Width, i / m.Width})
+		}
+	}
+	return
+}
+
+type AreaKind int
+
+const (
+	AREA_ROOM     AreaKind = iota //open space, at least 3x3
+	AREA_CORRIDOR                 //narrow passages (and doorways) between rooms
+)
+
+//An Area is a room or corridor found by BuildAreaGraph().
+type Area struct {
+	Kind   AreaKind
+	Tiles  []Coord
+	Bounds Rect
+	Links  []int //indices of the areas this one touches, in ascending order
+}
+
+//AreaGraph describes a map as rooms and the corridors between them. Rooms are made of tiles that are
+//part of an open 3x3 block, corridors are everything else that's passable. Rooms only ever link to
+//corridors and vice versa.
+type AreaGraph struct {
+	Width, Height int
+	Areas         []Area
+	labels        []int
+}
+
+//Splits the passable parts of the map into rooms and corridors, and works out how they connect.
+func (m *TileMap) BuildAreaGraph(cost PathCost) *AreaGraph {
+	cost = regionCost(cost)
+	n := len(m.Tiles)
+	passable := make([]bool, n)
+	for i := range passable {
+		passable[i] = cost(m, i%m.Width, i/m.Width) >= 0
+	}
+
+	//mark every tile covered by a fully passable 3x3 block as room
+	room := make([]bool, n)
+	for y := 1; y < m.Height-1; y++ {
+		for x := 1; x < m.Width-1; x++ {
+			open := true
+			for i := 0; i < 9 && open; i++ {
+				open = passable[x+i%3-1+(y+i/3-1)*m.Width]
+			}
+			if !open {
+				continue
+			}
+			for i := 0; i < 9; i++ {
+				room[x+i%3-1+(y+i/3-1)*m.Width] = true
+			}
+		}
+	}
+
+	ag := &AreaGraph{Width: m.Width, Height: m.Height, labels: make([]int, n)}
+	for i := range ag.labels {
+		ag.labels[i] = -1
+	}
+
+	for _, kind := range []AreaKind{AREA_ROOM, AREA_CORRIDOR} {
+		isRoom := kind == AREA_ROOM
+		regions := m.floodRegions(func(x, y int) bool {
+			return passable[x+y*m.Width] && room[x+y*m.Width] == isRoom
+		})
+		for _, r := range regions {
+			for _, c := range r {
+				ag.labels[c.X+c.Y*m.Width] = len(ag.Areas)
+			}
+			ag.Areas = append(ag.Areas, Area{Kind: kind, Tiles: r, Bounds: boundingRect(r)})
+		}
+	}
+
+	for a := range ag.Areas {
+		linked := make(map[int]bool)
+		for _, c := range ag.Areas[a].Tiles {
+			for _, d := range dirs4 {
+				if b := ag.AreaAt(c.X+d.X, c.Y+d.Y); b >= 0 && b != a && !linked[b] {
+					linked[b] = true
+					ag.Areas[a].Links = append(ag.Areas[a].Links, b)
+				}
+			}
+		}
+		sort.Ints(ag.Areas[a].Links)
+	}
+
+	return ag
+}
+
+//Returns the index of the area containing (x, y), or -1 if it's impassable or off the map.
+func (ag *AreaGraph) AreaAt(x, y int) int {
+	if !CheckBounds(x, y, ag.Width, ag.Height) {
+		return -1
+	}
+	return ag.labels[x+y*ag.Width]
+}
+
+//Returns the indices of all the rooms, or all the corridors.
+func (ag *AreaGraph) AreasOfKind(kind AreaKind) (areas []int) {
+	for i, a := range ag.Areas {
+		if a.Kind == kind {
+			areas = append(areas, i)
+		}
+	}
+	return
+}
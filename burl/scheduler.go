@@ -0,0 +1,247 @@
+package burl
+
+import "sort"
+
+const (
+	ACTION_COST    = 100 //energy needed to act, and the cost of a normal action
+	ACTION_PENDING = -1  //returned by Act() when the actor is waiting on input. see Scheduler.Run()
+	SPEED_NORMAL   = 100 //energy gained per turn by a normal speed actor, enough for one normal action
+)
+
+//Actor is anything that takes turns: the player, monsters, traps that go off every few turns.
+type Actor interface {
+	//Energy gained each turn. SPEED_NORMAL gets one normal action per turn, 200 gets two, 50 gets one
+	//every other turn.
+	Speed() int
+
+	//Takes an action and returns how much energy it cost. ACTION_COST is a normal action, so an attack
+	//costing 150 takes half as long again. Return ACTION_PENDING if the actor needs input before it can
+	//decide what to do (usually the player), and the scheduler will stop and wait. Actions costing 0 are
+	//free, the actor gets to go again immediately.
+	Act(s *Scheduler) int
+}
+
+//Scheduler runs the game's turns with an energy system: every turn, each actor gains energy equal to
+//its speed, and actors with at least ACTION_COST energy act, most energy first (ties go to whoever was
+//added first). Acting uses up energy, so fast actors act more often and expensive actions make actors
+//wait longer for their next go.
+//
+//Call Run() from your state's Update() (or after handling input). It processes turns until an actor
+//needs input or something calls Pause().
+type Scheduler struct {
+	turn    int
+	actors  []*schedActor
+	ready   []*schedActor //actors with enough energy to act this turn, in order
+	effects []*Effect
+	hooks   []func() //run at the start of every turn, see EveryTurn()
+	seq     int
+	paused  bool
+	opening bool //effects for the new turn are still being run, see nextTurn()
+}
+
+type schedActor struct {
+	actor   Actor
+	energy  int
+	seq     int
+	removed bool
+}
+
+//An Effect is something scheduled to happen in a later turn: a bomb going off, a potion wearing off.
+type Effect struct {
+	turn      int
+	seq       int
+	fn        func()
+	cancelled bool
+}
+
+//Stops the effect from happening, if it hasn't already.
+func (e *Effect) Cancel() {
+	e.cancelled = true
+}
+
+func NewScheduler() *Scheduler {
+	return new(Scheduler)
+}
+
+//Returns the current turn number. Turn 0 is before anything has happened.
+func (s *Scheduler) Turn() int {
+	return s.turn
+}
+
+//Adds an actor, starting with no energy. It'll get its first go once it's built up ACTION_COST.
+func (s *Scheduler) Add(a Actor) {
+	s.AddWithEnergy(a, 0)
+}
+
+//Adds an actor with some energy already, so it can act sooner. Energy is handed out at the start of
+//each turn, so giving the player a little (1 will do) means they go first in the first turn.
+func (s *Scheduler) AddWithEnergy(a Actor, energy int) {
+	if s.find(a) != nil {
+		LogError("Actor already in scheduler.")
+		return
+	}
+	s.actors = append(s.actors, &schedActor{actor: a, energy: energy, seq: s.seq})
+	s.seq++
+}
+
+//Removes an actor. Safe to call from inside Act(), even for the actor that's acting.
+func (s *Scheduler) Remove(a Actor) {
+	for i, sa := range s.actors {
+		if sa.actor == a {
+			sa.removed = true
+			s.actors = append(s.actors[:i], s.actors[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Scheduler) find(a Actor) *schedActor {
+	for _, sa := range s.actors {
+		if sa.actor == a {
+			return sa
+		}
+	}
+	return nil
+}
+
+//Returns the actor's energy, or 0 if it's not in the scheduler.
+func (s *Scheduler) Energy(a Actor) int {
+	if sa := s.find(a); sa != nil {
+		return sa.energy
+	}
+	return 0
+}
+
+//Sets the actor's energy. Stuns and hastes and things. Takes effect straight away: an actor still
+//waiting for its go this turn loses it if its energy drops below ACTION_COST, or moves up or down the
+//order otherwise. Actors that weren't going to act this turn don't get to, however much energy they're
+//given, until the next turn.
+func (s *Scheduler) SetEnergy(a Actor, energy int) {
+	sa := s.find(a)
+	if sa == nil {
+		return
+	}
+	sa.energy = energy
+
+	for i := range s.ready {
+		if s.ready[i] == sa {
+			if energy < ACTION_COST {
+				s.ready = append(s.ready[:i], s.ready[i+1:]...)
+			}
+			s.sortReady()
+			return
+		}
+	}
+}
+
+//Schedules fn to run at the start of the turn delay turns from now (at least 1). Effects due in the
+//same turn run in the order they were scheduled, before any actors act.
+func (s *Scheduler) Schedule(delay int, fn func()) *Effect {
+	e := &Effect{turn: s.turn + Max(delay, 1), seq: s.seq, fn: fn}
+	s.seq++
+
+	//keep effects sorted by turn, then scheduling order
+	i := sort.Search(len(s.effects), func(i int) bool { return s.effects[i].turn > e.turn })
+	s.effects = append(s.effects, nil)
+	copy(s.effects[i+1:], s.effects[i:])
+	s.effects[i] = e
+	return e
+}
+
+//Runs fn at the start of every turn, after that turn's effects and before anyone acts. For things that
+//have to happen once a turn ahead of all the actors, like ticking a StatusManager.
+func (s *Scheduler) EveryTurn(fn func()) {
+	s.hooks = append(s.hooks, fn)
+}
+
+//Stops Run() after the current action or effect finishes. Good for letting an animation play out. If an
+//effect pauses, the rest of the turn's effects run when Run() is next called.
+func (s *Scheduler) Pause() {
+	s.paused = true
+}
+
+//Processes turns until an actor needs input, Pause() is called, or maxTurns new turns have started
+//(0 for no limit, but make sure something will wait for input or pause!). Returns true if it stopped
+//because an actor is waiting for input: that actor goes first when Run() is next called.
+func (s *Scheduler) Run(maxTurns int) bool {
+	s.paused = false
+	turns := 0
+
+	for !s.paused {
+		if s.opening {
+			s.openTurn()
+			continue
+		}
+		if len(s.ready) == 0 {
+			if maxTurns > 0 && turns >= maxTurns {
+				return false
+			}
+			s.nextTurn()
+			turns++
+			continue
+		}
+
+		//take the actor out of the queue while it acts, so SetEnergy() can reorder the rest
+		sa := s.ready[0]
+		s.ready = s.ready[1:]
+		if sa.removed {
+			continue
+		}
+
+		cost := sa.actor.Act(s)
+		if cost == ACTION_PENDING {
+			s.ready = append([]*schedActor{sa}, s.ready...)
+			return true
+		}
+
+		sa.energy -= cost
+		if !sa.removed && sa.energy >= ACTION_COST {
+			s.ready = append(s.ready, sa)
+			s.sortReady()
+		}
+	}
+
+	return false
+}
+
+//Starts a new turn.
+func (s *Scheduler) nextTurn() {
+	s.turn++
+	s.opening = true
+	s.openTurn()
+}
+
+//Runs effects that are due and the EveryTurn() functions, then gives everyone their energy and works
+//out who can act. If an effect pauses the scheduler, this stops and picks up where it left off next time.
+func (s *Scheduler) openTurn() {
+	for len(s.effects) > 0 && s.effects[0].turn <= s.turn {
+		if s.paused {
+			return
+		}
+		e := s.effects[0]
+		s.effects = s.effects[1:]
+		if !e.cancelled {
+			e.fn()
+		}
+	}
+	s.opening = false
+
+	for _, fn := range s.hooks {
+		fn()
+	}
+
+	for _, sa := range s.actors {
+		sa.energy += sa.actor.Speed()
+		if sa.energy >= ACTION_COST {
+			s.ready = append(s.ready, sa)
+		}
+	}
+	s.sortReady()
+}
+
+func (s *Scheduler) sortReady() {
+	sort.Slice(s.ready, func(i, j int) bool {
+		a, b := s.ready[i], s.ready[j]
+		return a.energy > b.energy || (a.energy == b.energy && a.seq < b.seq)
+	})
+}
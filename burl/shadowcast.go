@@ -11,17 +11,32 @@ func (m *TileMap) ShadowCast(x, y, radius int, fn Cast) {
 		return
 	}
 	fn(m, x, y, 0, radius)
+	visit := func(mx, my, d int) { fn(m, mx, my, d, radius) }
 	for i := 0; i < 8; i++ {
-		m.scan(x, y, 1, 1.0, 0.0, radius, rMatrix[i], i%2 == 0, fn)
+		scan(m, x, y, 1, 1.0, 0.0, radius, rMatrix[i], i%2 == 0, visit)
 	}
 }
 
+//Anything the shadowcaster can scan over. TileMaps and ChunkMaps, so far.
+type shadowGrid interface {
+	inBounds(x, y int) bool
+	transparentAt(x, y int) bool //only called for coords that are inBounds
+}
+
+func (m *TileMap) inBounds(x, y int) bool {
+	return CheckBounds(x, y, m.Width, m.Height)
+}
+
+func (m *TileMap) transparentAt(x, y int) bool {
+	return m.Tiles[x+y*m.Width].Transparent()
+}
+
 //TODO: General cleanup. Direct port from python, not exactly golangish.
 //NOTE: The 'cull' bool controls the logic for ensuring the 8 passes don't overlap at the edges.
 //It is set to true for the odd-numbered scans. The shadowcaster still visits these squares twice,
 //but the function fn is not run twice. Trust me Ben, this was the best way you could think of and
 //your other solutions created crazy behaviour. Leave it alone!
-func (m *TileMap) scan(x, y, row int, slope1, slope2 float32, radius int, r [4]int, cull bool, fn Cast) {
+func scan(g shadowGrid, x, y, row int, slope1, slope2 float32, radius int, r [4]int, cull bool, visit func(x, y, d int)) {
 	if slope1 < slope2 {
 		return
 	}
@@ -33,7 +48,7 @@ func (m *TileMap) scan(x, y, row int, slope1, slope2 float32, radius int, r [4]i
 		//scan row
 		for dx, dy, newStart := -j, -j, slope1; dx <= 0; dx++ {
 			mx, my := x+dx*r[0]+dy*r[1], y+dx*r[2]+dy*r[3] //map coordinates
-			if !CheckBounds(mx, my, m.Width, m.Height) {
+			if !g.inBounds(mx, my) {
 				continue
 			}
 			lSlope, rSlope := (float32(dx)-0.5)/(float32(dy)+0.5), (float32(dx)+0.5)/(float32(dy)-0.5)
@@ -45,12 +60,12 @@ func (m *TileMap) scan(x, y, row int, slope1, slope2 float32, radius int, r [4]i
 			} else {
 				if d := Distance(0, 0, dx, dy); d < radius*radius {
 					if !cull || !(dx == 0 || dy == 0 || dx == dy) {
-						fn(m, mx, my, d, radius)
+						visit(mx, my, d)
 					}
 				}
 				//scanning a block
 				if blocked {
-					if m.Tiles[mx+my*m.Width].Transparent() {
+					if g.transparentAt(mx, my) {
 						blocked = false
 						slope1 = newStart
 					} else {
@@ -58,9 +73,9 @@ func (m *TileMap) scan(x, y, row int, slope1, slope2 float32, radius int, r [4]i
 					}
 				} else {
 					//blocked square, commence child scan
-					if !m.Tiles[mx+my*m.Width].Transparent() && j < radius {
+					if !g.transparentAt(mx, my) && j < radius {
 						blocked = true
-						m.scan(x, y, j+1, newStart, lSlope, radius, r, cull, fn)
+						scan(g, x, y, j+1, newStart, lSlope, radius, r, cull, visit)
 						newStart = rSlope
 					}
 				}
@@ -73,18 +88,17 @@ func (m *TileMap) scan(x, y, row int, slope1, slope2 float32, radius int, r [4]i
 //that the shadowcaster will deliver
 type Cast func(m *TileMap, x, y, d, r int)
 
-//Run this over a tilemap to light squares. Linearly interpolates from max (255) at center to 0 at r
+//Run this over a tilemap to light squares with white light. Linearly interpolates from max (255) at
+//center to 0 at r (in distance squared). For coloured light or other falloffs, see LightenColour().
 func Lighten(m *TileMap, x, y, d, r int) {
-	m.Tiles[x+y*m.Width].Light.Bright += (255 - int(255*float32(d)/float32(r*r)))
+	i := FALLOFF_QUADRATIC.Intensity(d, r)
+	m.Tiles[x+y*m.Width].Light.add(i, i, i, i)
 }
 
 //Same as above, but opposite.
 func Darken(m *TileMap, x, y, d, r int) {
-	m.Tiles[x+y*m.Width].Light.Bright -= (255 - int(255*float32(d)/float32(r*r)))
-
-	if m.Tiles[x+y*m.Width].Light.Bright < 0 {
-		m.Tiles[x+y*m.Width].Light.Bright = 0
-	}
+	i := FALLOFF_QUADRATIC.Intensity(d, r)
+	m.Tiles[x+y*m.Width].Light.add(-i, -i, -i, -i)
 }
 
 //gets a list of the position of all empty tiles
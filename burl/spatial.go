@@ -0,0 +1,208 @@
+package burl
+
+import "sort"
+
+//Size of the buckets in the entity index, in tiles.
+const INDEX_BUCKET_SIZE = 8
+
+//entityIndex is a spatial hash of all the entities on a map, so searching for entities near a point
+//doesn't have to look at every tile. It's built the first time a map is searched, and after that kept
+//up to date by AddEntity(), RemoveEntity(), MoveEntity() and friends.
+type entityIndex struct {
+	bw, bh  int //size of the map in buckets
+	buckets [][]*indexEntry
+	entries map[Entity]*indexEntry
+	nextSeq int
+}
+
+type indexEntry struct {
+	e    Entity
+	x, y int
+	seq  int //order added. queries return entities in this order, so results are stable.
+}
+
+func newEntityIndex(m *TileMap) *entityIndex {
+	bw, bh := (m.Width+INDEX_BUCKET_SIZE-1)/INDEX_BUCKET_SIZE, (m.Height+INDEX_BUCKET_SIZE-1)/INDEX_BUCKET_SIZE
+	idx := &entityIndex{bw: bw, bh: bh, buckets: make([][]*indexEntry, bw*bh), entries: make(map[Entity]*indexEntry)}
+	for i, t := range m.Tiles {
+		for _, e := range t.GetEntities() {
+			idx.add(e, i%m.Width, i/m.Width)
+		}
+	}
+	return idx
+}
+
+//Returns the map's entity index, building it if need be.
+func (m *TileMap) entityIndex() *entityIndex {
+	if m.index == nil {
+		m.index = newEntityIndex(m)
+	}
+	return m.index
+}
+
+func (idx *entityIndex) bucket(x, y int) int {
+	return x/INDEX_BUCKET_SIZE + (y/INDEX_BUCKET_SIZE)*idx.bw
+}
+
+func (idx *entityIndex) add(e Entity, x, y int) {
+	en := &indexEntry{e, x, y, idx.nextSeq}
+	idx.nextSeq++
+	idx.entries[e] = en
+	b := idx.bucket(x, y)
+	idx.buckets[b] = append(idx.buckets[b], en)
+}
+
+func (idx *entityIndex) remove(e Entity) {
+	en := idx.entries[e]
+	if en == nil {
+		return
+	}
+	delete(idx.entries, e)
+	idx.unbucket(en)
+}
+
+//Moves an entity, keeping its place in the order.
+func (idx *entityIndex) move(e Entity, x, y int) {
+	en := idx.entries[e]
+	if en == nil {
+		return
+	}
+	if idx.bucket(x, y) != idx.bucket(en.x, en.y) {
+		idx.unbucket(en)
+		b := idx.bucket(x, y)
+		idx.buckets[b] = append(idx.buckets[b], en)
+	}
+	en.x, en.y = x, y
+}
+
+func (idx *entityIndex) unbucket(en *indexEntry) {
+	b := idx.bucket(en.x, en.y)
+	for i, be := range idx.buckets[b] {
+		if be == en {
+			idx.buckets[b] = append(idx.buckets[b][:i], idx.buckets[b][i+1:]...)
+			return
+		}
+	}
+}
+
+//Calls fn on every entry in the buckets overlapping the area from (x1, y1) to (x2, y2) inclusive.
+func (idx *entityIndex) scan(x1, y1, x2, y2 int, fn func(en *indexEntry)) {
+	bx1, by1 := Max(x1, 0)/INDEX_BUCKET_SIZE, Max(y1, 0)/INDEX_BUCKET_SIZE
+	bx2, by2 := Min(x2/INDEX_BUCKET_SIZE, idx.bw-1), Min(y2/INDEX_BUCKET_SIZE, idx.bh-1)
+	for by := by1; by <= by2; by++ {
+		for bx := bx1; bx <= bx2; bx++ {
+			for _, en := range idx.buckets[bx+by*idx.bw] {
+				fn(en)
+			}
+		}
+	}
+}
+
+func sortedEntities(ens []*indexEntry) []Entity {
+	sort.Slice(ens, func(i, j int) bool { return ens[i].seq < ens[j].seq })
+	es := make([]Entity, len(ens))
+	for i, en := range ens {
+		es[i] = en.e
+	}
+	return es
+}
+
+//Returns every entity on the map, in the order they were added. Entities that were already on the map
+//when it was first searched come first, in scan order.
+func (m *TileMap) AllEntities() []Entity {
+	idx := m.entityIndex()
+	ens := make([]*indexEntry, 0, len(idx.entries))
+	for _, en := range idx.entries {
+		ens = append(ens, en)
+	}
+	return sortedEntities(ens)
+}
+
+//Returns the position of entity e on the map, and whether it's on the map at all.
+func (m *TileMap) EntityPosition(e Entity) (x, y int, ok bool) {
+	if en := m.entityIndex().entries[e]; en != nil {
+		return en.x, en.y, true
+	}
+	return 0, 0, false
+}
+
+//Returns the entities within radius of (x, y), ie. at a squared distance of at most radius*radius, in
+//the same order as AllEntities().
+func (m *TileMap) EntitiesInRadius(x, y, radius int) []Entity {
+	var ens []*indexEntry
+	m.entityIndex().scan(x-radius, y-radius, x+radius, y+radius, func(en *indexEntry) {
+		if Distance(x, y, en.x, en.y) <= radius*radius {
+			ens = append(ens, en)
+		}
+	})
+	return sortedEntities(ens)
+}
+
+//Returns the entities inside r, in the same order as AllEntities().
+func (m *TileMap) EntitiesInRect(r Rect) []Entity {
+	var ens []*indexEntry
+	m.entityIndex().scan(r.X, r.Y, r.X+r.W-1, r.Y+r.H-1, func(en *indexEntry) {
+		if IsInside(en.x, en.y, r) {
+			ens = append(ens, en)
+		}
+	})
+	return sortedEntities(ens)
+}
+
+//Returns the k entities closest to (x, y), closest first. Entities the same distance away are in the
+//same order as AllEntities(). If filter isn't nil, only entities it returns true for are considered.
+func (m *TileMap) NearestEntities(x, y, k int, filter func(e Entity) bool) []Entity {
+	idx := m.entityIndex()
+	if k <= 0 {
+		return nil
+	}
+
+	var found []*indexEntry
+	less := func(i, j int) bool {
+		di, dj := Distance(x, y, found[i].x, found[i].y), Distance(x, y, found[j].x, found[j].y)
+		return di < dj || (di == dj && found[i].seq < found[j].seq)
+	}
+
+	//search rings of buckets outwards until the closest unsearched bucket is further away than the
+	//kth closest entity found
+	bx, by := Clamp(x/INDEX_BUCKET_SIZE, 0, idx.bw-1), Clamp(y/INDEX_BUCKET_SIZE, 0, idx.bh-1)
+	for r := 0; ; r++ {
+		for ry := by - r; ry <= by+r; ry++ {
+			for rx := bx - r; rx <= bx+r; rx++ {
+				if Max(Abs(rx-bx), Abs(ry-by)) != r || !CheckBounds(rx, ry, idx.bw, idx.bh) {
+					continue
+				}
+				for _, en := range idx.buckets[rx+ry*idx.bw] {
+					if filter == nil || filter(en.e) {
+						found = append(found, en)
+					}
+				}
+			}
+		}
+
+		covered := bx-r <= 0 && by-r <= 0 && bx+r >= idx.bw-1 && by+r >= idx.bh-1
+		if covered {
+			break
+		}
+
+		if len(found) >= k {
+			//distance from (x, y) to the nearest tile outside the searched square
+			edge := Max(Min(Min(x-(bx-r)*INDEX_BUCKET_SIZE, (bx+r+1)*INDEX_BUCKET_SIZE-1-x), Min(y-(by-r)*INDEX_BUCKET_SIZE, (by+r+1)*INDEX_BUCKET_SIZE-1-y))+1, 0)
+			sort.Slice(found, less)
+			if Distance(x, y, found[k-1].x, found[k-1].y) < edge*edge {
+				break
+			}
+		}
+	}
+
+	sort.Slice(found, less)
+	if len(found) > k {
+		found = found[:k]
+	}
+
+	es := make([]Entity, len(found))
+	for i, en := range found {
+		es[i] = en.e
+	}
+	return es
+}
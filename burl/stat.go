@@ -5,25 +5,92 @@ import "bytes"
 import "fmt"
 
 //Stat struct holds the value of a modifiable statistic, always an int. Enforces a max and min
-//value, other nice things. Eventually will support temporary modifiers and the like (I think????).
+//value, other nice things. Stats can have modifiers (buffs, curses, equipment bonuses, see
+//AddModifier()), can be derived from other stats (see Derive()), and can tell you when they change
+//(see Watch()).
+//
+//The val, min and max fields are the base values. Get(), Min() and Max() return the values with
+//modifiers applied, and the value is always kept between the modified min and max. NOTE: modifiers and
+//watchers are held in slices, so copies of a stat share them. Keep stats in one place (a field in your
+//entity struct, say) and pass pointers around.
 type Stat struct {
 	val int
 	max int
 	min int
+
+	mods      []StatModifier
+	watchers  []statWatcher
+	watchID   int
+	derive    func() int //formula for derived stats. see Derive()
+	notifying bool
+}
+
+//Parts of a stat that a modifier can change. Combine with |.
+type StatPart int
+
+const (
+	STAT_VAL StatPart = 1 << iota
+	STAT_MIN
+	STAT_MAX
+)
+
+type ModifierKind int
+
+const (
+	MOD_FLAT    ModifierKind = iota //adds Amount
+	MOD_PERCENT                     //adds Amount% (after flat modifiers are added). -50 halves it.
+)
+
+//What happens when a modifier is added to a stat that already has one from the same source.
+type StackRule int
+
+const (
+	STACK_ADD     StackRule = iota //both apply. drink two potions of strength, get twice as strong.
+	STACK_REFRESH                  //the new one replaces the old one, resetting the duration.
+	STACK_IGNORE                   //the old one stays and the new one is thrown away.
+)
+
+//A StatModifier changes a stat until it expires or is removed. A modifier on STAT_VAL can't push the
+//value past the max, so to buff a stat that sits at its max (strength and the like, made with
+//NewStat()) target STAT_VAL|STAT_MAX.
+type StatModifier struct {
+	Source   string //what the modifier came from, eg. "Potion of Strength". used for stacking and removal.
+	Target   StatPart
+	Kind     ModifierKind
+	Amount   int
+	Duration int //turns left before it expires, see Tick(). 0 lasts until removed.
+	Stacking StackRule
+}
+
+type statWatcher struct {
+	id int
+	fn func(s *Stat)
 }
 
 //make a new stat with value at max, and min at 0.
 func NewStat(v int) Stat {
-	return Stat{v, v, 0}
+	return Stat{val: v, max: v, min: 0}
 }
 
 func (s Stat) Get() int {
+	return Clamp(s.apply(STAT_VAL, s.val, 0), s.Min(), s.Max())
+}
+
+//Returns the value without modifiers.
+func (s Stat) Base() int {
 	return s.val
 }
 
-//Manually set a value. If v > s.Max, sets to max. if v < s.min, sets to min.
+//Manually set a value. If v > s.Max, sets to max. if v < s.min, sets to min. This sets the base value,
+//modifiers on the value are applied on top.
 func (s *Stat) Set(v int) {
-	s.val = Clamp(v, s.min, s.max)
+	if s.derive != nil {
+		LogError("Can't set a derived stat.")
+		return
+	}
+	old := *s
+	s.val = v
+	s.settle(old)
 }
 
 //Modifies the stat value. Takes a delta (which can of course be negative). Calling this with d = 0
@@ -33,26 +100,28 @@ func (s *Stat) Mod(d int) {
 }
 
 func (s Stat) Max() int {
-	return s.max
+	return Max(s.apply(STAT_MAX, s.max, 0), s.Min())
 }
 
 func (s Stat) Min() int {
-	return s.min
+	return s.apply(STAT_MIN, s.min, 0)
 }
 
-//Sets a new minimum. If this would make min > max, does nothing.
+//Sets a new (base) minimum. If this would make min > max, does nothing.
 func (s *Stat) SetMin(m int) {
 	if m <= s.max {
+		old := *s
 		s.min = m
-		s.val = Clamp(s.val, s.min, s.max)
+		s.settle(old)
 	}
 }
 
-//Sets a new maximum. If this would make max < min, does nothing.
+//Sets a new (base) maximum. If this would make max < min, does nothing.
 func (s *Stat) SetMax(m int) {
 	if m >= s.min {
+		old := *s
 		s.max = m
-		s.val = Clamp(s.val, s.min, s.max)
+		s.settle(old)
 	}
 }
 
@@ -67,35 +136,212 @@ func (s *Stat) ModMax(d int) {
 }
 
 func (s Stat) IsMax() bool {
-	return s.val == s.max
+	return s.Get() == s.Max()
 }
 
 func (s Stat) IsMin() bool {
-	return s.val == s.min
+	return s.Get() == s.Min()
 }
 
 //returns a % (0-100) for the stat. If min == val == max, returns 0.
 func (s Stat) GetPct() int {
-	if s.min == s.max {
+	min, max := s.Min(), s.Max()
+	if min == max {
 		return 0
 	} else {
-		return int(100 * (float32(s.val-s.min) / float32(s.max-s.min)))
+		return int(100 * (float32(s.Get()-min) / float32(max-min)))
 	}
 }
 
 func (s Stat) String() string {
-	return strconv.Itoa(s.val) + "/" + strconv.Itoa(s.max)
+	return strconv.Itoa(s.Get()) + "/" + strconv.Itoa(s.Max())
+}
+
+//Adds a modifier, following its stacking rule if the stat already has one from the same source.
+func (s *Stat) AddModifier(m StatModifier) {
+	old := *s
+	switch m.Stacking {
+	case STACK_REFRESH:
+		s.mods = s.withoutSource(m.Source)
+	case STACK_IGNORE:
+		if s.HasModifier(m.Source) {
+			return
+		}
+	}
+	s.mods = append(s.mods, m)
+	s.settle(old)
+}
+
+//Removes all modifiers from the given source.
+func (s *Stat) RemoveModifiers(source string) {
+	old := *s
+	s.mods = s.withoutSource(source)
+	s.settle(old)
+}
+
+//Removes every modifier.
+func (s *Stat) ClearModifiers() {
+	old := *s
+	s.mods = nil
+	s.settle(old)
+}
+
+//Reports whether the stat has a modifier from the given source.
+func (s Stat) HasModifier(source string) bool {
+	for _, m := range s.mods {
+		if m.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the stat's modifiers, in the order they were added.
+func (s Stat) Modifiers() []StatModifier {
+	return append([]StatModifier(nil), s.mods...)
+}
+
+//Counts down modifier durations by one turn, removing the ones that run out. Emits an
+//EV_STAT_MODIFIER_EXPIRED event for each, with the modifier's source as the message. Call this once
+//per turn for each stat, or from a Scheduler effect.
+func (s *Stat) Tick() {
+	old := *s
+	mods := make([]StatModifier, 0, len(s.mods)) //new slice so old keeps the modifiers for comparison
+	for _, m := range s.mods {
+		if m.Duration > 0 {
+			m.Duration--
+			if m.Duration == 0 {
+				PushEvent(NewEvent(EV_STAT_MODIFIER_EXPIRED, m.Source))
+				continue
+			}
+		}
+		mods = append(mods, m)
+	}
+	s.mods = mods
+	s.settle(old)
+}
+
+func (s Stat) withoutSource(source string) []StatModifier {
+	mods := make([]StatModifier, 0, len(s.mods))
+	for _, m := range s.mods {
+		if m.Source != source {
+			mods = append(mods, m)
+		}
+	}
+	return mods
+}
+
+//Returns base with the modifiers for part applied: flat ones first, then percentages. Modifiers that
+//also target skip are left out.
+func (s Stat) apply(part StatPart, base int, skip StatPart) int {
+	flat, pct := 0, 0
+	for _, m := range s.mods {
+		if m.Target&part == 0 || m.Target&skip != 0 {
+			continue
+		}
+		if m.Kind == MOD_PERCENT {
+			pct += m.Amount
+		} else {
+			flat += m.Amount
+		}
+	}
+	v := base + flat
+	return v + RoundFloatToInt(float64(v*pct)/100)
+}
+
+//Makes this a derived stat, whose value is worked out by formula whenever one of the stats it depends
+//on changes. Like NewStat(), the value is set at the max with a min of 0, and modifiers apply on top.
+//Derived stats can't be Set() or Mod()ed. The stat watches its dependencies from where it is, so don't
+//copy it afterwards.
+//
+//	c.Attack.Derive(func() int { return c.Strength.Get()*2 + c.Weapon.Damage }, &c.Strength)
+func (s *Stat) Derive(formula func() int, deps ...*Stat) {
+	s.derive = formula
+	for _, d := range deps {
+		d.Watch(func(*Stat) { s.recalculate() })
+	}
+	s.recalculate()
+}
+
+//Recomputes a derived stat. Call this if the formula uses something that isn't a stat.
+func (s *Stat) Recalculate() {
+	if s.derive != nil {
+		s.recalculate()
+	}
+}
+
+func (s *Stat) recalculate() {
+	old := *s
+	v := s.derive()
+	s.val, s.max, s.min = v, v, Min(0, v)
+	s.changed(old)
+}
+
+//Registers fn to be called whenever the stat's (modified) value, min or max changes. Returns an ID for
+//Unwatch().
+func (s *Stat) Watch(fn func(s *Stat)) int {
+	s.watchID++
+	s.watchers = append(s.watchers, statWatcher{s.watchID, fn})
+	return s.watchID
+}
+
+func (s *Stat) Unwatch(id int) {
+	for i, w := range s.watchers {
+		if w.id == id {
+			s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+//Keeps the base value inside the modified range, then notifies watchers. Modifiers that change the value
+//as well as the min or max move them together, so they're left out: otherwise a curse on strength would
+//eat into the base value and it wouldn't come back when the curse wore off.
+func (s *Stat) settle(old Stat) {
+	min := s.apply(STAT_MIN, s.min, STAT_VAL)
+	s.val = Clamp(s.val, min, Max(s.apply(STAT_MAX, s.max, STAT_VAL), min))
+	s.changed(old)
+}
+
+//Tells the watchers if anything they can see has changed since old.
+func (s *Stat) changed(old Stat) {
+	if s.notifying || (s.Get() == old.Get() && s.Min() == old.Min() && s.Max() == old.Max()) {
+		return
+	}
+
+	s.notifying = true //so a watcher changing the stat doesn't loop forever
+	for _, w := range s.watchers {
+		w.fn(s)
+	}
+	s.notifying = false
 }
 
 //NOTE: are these necessary? i forget if we need these, i don't think we do.
+//Modifiers are saved too, but watchers and derived stat formulas are not: Derive() again after loading.
 func (s Stat) GobEncode() ([]byte, error) {
 	var b bytes.Buffer
 	fmt.Fprintln(&b, s.min, s.max, s.val)
+	for _, m := range s.mods {
+		fmt.Fprintf(&b, "%d %d %d %d %d %q\n", m.Target, m.Kind, m.Amount, m.Duration, m.Stacking, m.Source)
+	}
 	return b.Bytes(), nil
 }
 
 func (s *Stat) GobDecode(data []byte) (err error) {
 	b := bytes.NewBuffer(data)
 	_, err = fmt.Fscanln(b, &s.min, &s.max, &s.val)
-	return err
+	if err != nil {
+		return err
+	}
+
+	s.mods = nil
+	for b.Len() > 0 {
+		var m StatModifier
+		_, err = fmt.Fscanf(b, "%d %d %d %d %d %q\n", &m.Target, &m.Kind, &m.Amount, &m.Duration, &m.Stacking, &m.Source)
+		if err != nil {
+			return err
+		}
+		s.mods = append(s.mods, m)
+	}
+	return nil
 }
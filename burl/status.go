@@ -0,0 +1,269 @@
+package burl
+
+//Status effects: poison, burning, haste, stun, that sort of thing. Define each kind of status once as a
+//StatusType, then apply it to entities through a StatusManager, which counts down durations and runs
+//tick callbacks for every entity at the same point in the turn. Have your Scheduler tick it at the start
+//of every turn with EveryTurn(sm.Tick), add it to the Scheduler as an Actor, or call Tick() yourself once
+//per turn.
+//
+//Status overlays are drawn by TileViews (and so MapViews) with their Statuses set, see TileView.Statuses.
+
+//Number of ticks each status overlay is shown for when an entity has more than one: renders for a
+//TileView, ticks passed to DrawMap() for a MapView. See TileView.Statuses.
+const STATUS_OVERLAY_TICKS = 30
+
+//StatusType describes a kind of status effect.
+type StatusType struct {
+	Name      string
+	Duration  int       //in turns. 0 lasts until removed.
+	Stacking  StackRule //what happens when applied to an entity that already has it, see Apply()
+	MaxStacks int       //for STACK_ADD. 0 for no limit.
+	Tags      []string  //for immunities. eg. poison might be tagged "poison" and "organic".
+	Overlay   StatusOverlay
+
+	OnApply  func(e Entity, s *Status) //called when first applied, not when stacked or refreshed
+	OnTick   func(e Entity, s *Status) //called once a turn, before the duration counts down
+	OnRemove func(e Entity, s *Status) //called when the status ends, expired or removed. Remaining is 0 if it expired (or never had a duration).
+}
+
+//How an entity with a status is drawn. GLYPH_NONE and COL_NONE leave that part of the entity alone, so
+//burning might just turn the background red.
+type StatusOverlay struct {
+	Glyph      int
+	ForeColour uint32
+	BackColour uint32
+}
+
+func (o StatusOverlay) empty() bool {
+	return o.Glyph == GLYPH_NONE && o.ForeColour == COL_NONE && o.BackColour == COL_NONE
+}
+
+//A status effect active on an entity.
+type Status struct {
+	Type      *StatusType
+	Remaining int //turns left. 0 if it lasts until removed.
+	Stacks    int
+
+	removed bool
+}
+
+//Optional interface for entities with built-in immunities, like a fire elemental that can't burn.
+type StatusImmune interface {
+	ImmuneTo(tag string) bool
+}
+
+type StatusManager struct {
+	entities []Entity //in the order they first got a status, so ticking always happens in the same order
+	statuses map[Entity][]*Status
+	immune   map[Entity]map[string]bool
+}
+
+func NewStatusManager() *StatusManager {
+	return &StatusManager{
+		statuses: make(map[Entity][]*Status),
+		immune:   make(map[Entity]map[string]bool),
+	}
+}
+
+//Applies a status to an entity, unless it's immune to one of the status's tags. If the entity already
+//has the status, the type's Stacking rule decides what happens: STACK_ADD adds a stack (up to MaxStacks)
+//and restarts the duration, STACK_REFRESH just restarts the duration, and STACK_IGNORE does nothing.
+//Returns the entity's status, or nil if it was immune.
+func (sm *StatusManager) Apply(e Entity, st *StatusType) *Status {
+	for _, tag := range st.Tags {
+		if sm.IsImmune(e, tag) {
+			return nil
+		}
+	}
+
+	if s := sm.Get(e, st.Name); s != nil {
+		switch st.Stacking {
+		case STACK_ADD:
+			if st.MaxStacks == 0 || s.Stacks < st.MaxStacks {
+				s.Stacks++
+			}
+			s.Remaining = st.Duration
+		case STACK_REFRESH:
+			s.Remaining = st.Duration
+		}
+		return s
+	}
+
+	s := &Status{Type: st, Remaining: st.Duration, Stacks: 1}
+	if _, ok := sm.statuses[e]; !ok {
+		sm.entities = append(sm.entities, e)
+	}
+	sm.statuses[e] = append(sm.statuses[e], s)
+	if st.OnApply != nil {
+		st.OnApply(e, s)
+	}
+	return s
+}
+
+//Removes the named status from an entity, if it has it.
+func (sm *StatusManager) Remove(e Entity, name string) {
+	if s := sm.Get(e, name); s != nil {
+		sm.end(e, s)
+	}
+}
+
+//Removes all statuses with the given tag from an entity. Good for cures: RemoveTagged(player, "poison").
+func (sm *StatusManager) RemoveTagged(e Entity, tag string) {
+	for _, s := range sm.Statuses(e) {
+		for _, t := range s.Type.Tags {
+			if t == tag {
+				sm.end(e, s)
+				break
+			}
+		}
+	}
+}
+
+//Removes all of an entity's statuses, and forgets its immunities. Call this when an entity dies or
+//otherwise leaves the game.
+func (sm *StatusManager) Clear(e Entity) {
+	for _, s := range sm.Statuses(e) {
+		sm.end(e, s)
+	}
+	delete(sm.immune, e)
+}
+
+//Returns the entity's named status, or nil if it doesn't have it.
+func (sm *StatusManager) Get(e Entity, name string) *Status {
+	for _, s := range sm.statuses[e] {
+		if s.Type.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (sm *StatusManager) Has(e Entity, name string) bool {
+	return sm.Get(e, name) != nil
+}
+
+//Returns the entity's statuses, in the order they were applied.
+func (sm *StatusManager) Statuses(e Entity) []*Status {
+	return append([]*Status(nil), sm.statuses[e]...)
+}
+
+//Makes an entity immune (or not) to statuses with the given tag. Becoming immune doesn't remove
+//statuses the entity already has, use RemoveTagged() for that.
+func (sm *StatusManager) SetImmune(e Entity, tag string, immune bool) {
+	if immune {
+		if sm.immune[e] == nil {
+			sm.immune[e] = make(map[string]bool)
+		}
+		sm.immune[e][tag] = true
+	} else if sm.immune[e] != nil {
+		delete(sm.immune[e], tag)
+	}
+}
+
+//Reports whether an entity is immune to statuses with the given tag, either because of SetImmune() or
+//because it implements StatusImmune.
+func (sm *StatusManager) IsImmune(e Entity, tag string) bool {
+	if sm.immune[e][tag] {
+		return true
+	}
+	if si, ok := e.(StatusImmune); ok {
+		return si.ImmuneTo(tag)
+	}
+	return false
+}
+
+//Runs a turn for every status: OnTick is called, then the duration counts down. Statuses that run out
+//are removed, and an EV_STATUS_EXPIRED event is emitted for each with the status's name as the message.
+//Entities are processed in the order they first got a status, and each entity's statuses in the order
+//they were applied. Callbacks can safely apply and remove statuses.
+func (sm *StatusManager) Tick() {
+	for _, e := range append([]Entity(nil), sm.entities...) {
+		for _, s := range sm.Statuses(e) {
+			if s.removed {
+				continue
+			}
+			if s.Type.OnTick != nil {
+				s.Type.OnTick(e, s)
+				if s.removed {
+					continue
+				}
+			}
+			if s.Remaining > 0 {
+				s.Remaining--
+				if s.Remaining == 0 {
+					sm.end(e, s)
+					PushEvent(NewEvent(EV_STATUS_EXPIRED, s.Type.Name))
+				}
+			}
+		}
+	}
+}
+
+//StatusManagers are Actors, so they can go in a Scheduler and tick once a turn. Like any other actor,
+//it takes its go when its energy comes up, so other actors may act before statuses tick in a turn. Use
+//Scheduler.EveryTurn(sm.Tick) instead if statuses need to tick before anyone acts.
+func (sm *StatusManager) Speed() int {
+	return SPEED_NORMAL
+}
+
+func (sm *StatusManager) Act(s *Scheduler) int {
+	sm.Tick()
+	return ACTION_COST
+}
+
+//Removes a status and calls its OnRemove.
+func (sm *StatusManager) end(e Entity, s *Status) {
+	if s.removed {
+		return
+	}
+	s.removed = true
+
+	ss := sm.statuses[e]
+	for i := range ss {
+		if ss[i] == s {
+			ss = append(ss[:i:i], ss[i+1:]...) //copy, so Statuses() slices being looped over aren't disturbed
+			break
+		}
+	}
+	if len(ss) > 0 {
+		sm.statuses[e] = ss
+	} else {
+		delete(sm.statuses, e)
+		for i := range sm.entities {
+			if sm.entities[i] == e {
+				sm.entities = append(sm.entities[:i], sm.entities[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if s.Type.OnRemove != nil {
+		s.Type.OnRemove(e, s)
+	}
+}
+
+//Applies the overlays of e's statuses to v. With more than one overlay they take turns, each shown for
+//STATUS_OVERLAY_TICKS ticks. TileViews do this for you, see TileView.Statuses.
+func (sm *StatusManager) ApplyOverlay(e Entity, v Visuals, tick int) Visuals {
+	var overlays []StatusOverlay
+	for _, s := range sm.statuses[e] {
+		if !s.Type.Overlay.empty() {
+			overlays = append(overlays, s.Type.Overlay)
+		}
+	}
+	if len(overlays) == 0 {
+		return v
+	}
+
+	o := overlays[(tick/STATUS_OVERLAY_TICKS)%len(overlays)]
+	if o.Glyph != GLYPH_NONE {
+		v.Glyph = o.Glyph
+	}
+	if o.ForeColour != COL_NONE {
+		v.ForeColour = o.ForeColour
+	}
+	if o.BackColour != COL_NONE {
+		v.BackColour = o.BackColour
+	}
+	return v
+}
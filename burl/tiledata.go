@@ -1,28 +1,51 @@
 package burl
 
 var tiledata []tileTypeData
+var tileNames map[string]int //tile type indices by name
 
 type tileTypeData struct {
 	name        string
 	passable    bool
 	transparent bool
 	vis         Visuals
+	moveCost    int       //cost to walk over this tile, used by pathfinding. at least 1.
+	desc        string
+	variants    []Visuals //alternate visuals. Tile.Variant n > 0 uses variants[n-1]
+	properties  map[string]interface{}
 }
 
 //Inits the tile data repository, which for now is just a slice of datas. Also loads a NOTHING entry.
 func init() {
 	//tiledata[TILETYPE]
 	tiledata = make([]tileTypeData, 1)
+	tileNames = map[string]int{"": 0} //the blank tile type
 	LoadTileData("Nothing", false, true, 0, COL_BLACK)
 }
 
-//Adds a new entry to the tile data respoitory. Returns the index for the data in the repo.
-//TODO: load from file.
+//Adds a new entry to the tile data respoitory. Returns the index for the data in the repo. Names should
+//be unique, GetTileType() and saved maps only know about the first tile type with a given name. For
+//more options, define tile types in a file and use LoadTileDataFile().
 func LoadTileData(name string, pass, trans bool, glyph int, c uint32) int {
-	tiledata = append(tiledata, tileTypeData{name, pass, trans, Visuals{glyph, c, COL_BLACK}})
+	return addTileData(tileTypeData{name: name, passable: pass, transparent: trans, vis: Visuals{glyph, c, COL_BLACK}, moveCost: 1})
+}
+
+//Adds tile data to the repo. Complains if the name is taken, but adds it anyways.
+func addTileData(td tileTypeData) int {
+	tiledata = append(tiledata, td)
+	if t, ok := tileNames[td.name]; ok && td.name != "" {
+		LogError("Tile type \"", td.name, "\" already exists (", t, "), tile type ", len(tiledata)-1, " can't be found by name.")
+	} else if td.name != "" {
+		tileNames[td.name] = len(tiledata) - 1
+	}
 	return len(tiledata) - 1
 }
 
+//Returns the index of the tile type with the given name. Returns false if there isn't one.
+func GetTileType(name string) (int, bool) {
+	t, ok := tileNames[name]
+	return t, ok
+}
+
 func GetName(t int) string {
 	if t < len(tiledata) {
 		return tiledata[t].name
@@ -54,3 +77,55 @@ func GetTileVisuals(t int) Visuals {
 		return Visuals{0, COL_BLACK, COL_BLACK}
 	}
 }
+
+//Returns the visuals for a variant of a tile type. Variant 0 is the normal look of the tile. Variants
+//the tile type doesn't have fall back to the normal look.
+func GetTileVariantVisuals(t, variant int) Visuals {
+	if t < len(tiledata) && variant > 0 && variant <= len(tiledata[t].variants) {
+		return tiledata[t].variants[variant-1]
+	}
+	return GetTileVisuals(t)
+}
+
+//Returns the number of alternate looks the tile type has (not including the normal one).
+func GetNumVariants(t int) int {
+	if t < len(tiledata) {
+		return len(tiledata[t].variants)
+	} else {
+		return 0
+	}
+}
+
+//Returns the movement cost of the tile type. Defaults to 1.
+func GetMoveCost(t int) int {
+	if t < len(tiledata) {
+		return tiledata[t].moveCost
+	} else {
+		return 1
+	}
+}
+
+func GetDescription(t int) string {
+	if t < len(tiledata) {
+		return tiledata[t].desc
+	} else {
+		return ""
+	}
+}
+
+//Returns a custom property of the tile type, as defined in its data file. Values are whatever the
+//JSON decoder made of them (bool, float64, string, []interface{}, map[string]interface{}).
+func GetTileProperty(t int, key string) (interface{}, bool) {
+	if t < len(tiledata) {
+		p, ok := tiledata[t].properties[key]
+		return p, ok
+	}
+	return nil, false
+}
+
+//Reports whether the tile type has a custom property set to true. Handy for flags like "flammable".
+func HasTileFlag(t int, key string) bool {
+	p, _ := GetTileProperty(t, key)
+	b, _ := p.(bool)
+	return b
+}
@@ -0,0 +1,328 @@
+package burl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Tile data files are JSON lists of tile types. Every tile type needs a name, everything else is optional:
+//
+//	[
+//		{
+//			"name": "Grass",
+//			"passable": true,
+//			"transparent": true,
+//			"glyph": 44,
+//			"colour": "#00AA00",
+//			"back_colour": "#002200",
+//			"move_cost": 2,
+//			"description": "Long grass. Slow going.",
+//			"variants": [{"glyph": 34}, {"glyph": 39, "colour": "#448800"}],
+//			"flammable": true
+//		}
+//	]
+//
+//Colours are "#RRGGBB", "#AARRGGBB" or plain numbers. Variants inherit anything they don't specify
+//from the tile type. Any other keys (like "flammable" above) are kept as custom properties, see
+//GetTileProperty() and HasTileFlag().
+
+//TileDataError describes a problem with an entry in a tile data file.
+type TileDataError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e TileDataError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+//All the problems found in a tile data file.
+type TileDataErrors []TileDataError
+
+func (es TileDataErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i := range es {
+		msgs[i] = es[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type tileDataFile struct {
+	path    string
+	modTime time.Time
+	types   map[string]int //tile types loaded from the file, by name
+}
+
+var tileDataFiles []tileDataFile //files loaded so far, for hot reloading
+var tileDataWatch bool
+var tileDataLastCheck time.Time
+
+//Loads tile types from a JSON file (see above for the format). Loading a file again (like hot reloading
+//does) replaces the tile types it loaded last time, keeping their indices, so maps don't need to be
+//touched. Tile types are only replaced by ones with the same name from the same file. If the file has any
+//problems nothing is loaded, and the returned error (a TileDataErrors if the file could be parsed)
+//lists them all with line numbers.
+func LoadTileDataFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tds, err := parseTileData(path, data)
+	if err != nil {
+		return err
+	}
+
+	var f *tileDataFile
+	for i := range tileDataFiles {
+		if tileDataFiles[i].path == path {
+			f = &tileDataFiles[i]
+			break
+		}
+	}
+	if f == nil {
+		tileDataFiles = append(tileDataFiles, tileDataFile{path: path, types: make(map[string]int)})
+		f = &tileDataFiles[len(tileDataFiles)-1]
+	}
+
+	for _, td := range tds {
+		if t, ok := f.types[td.name]; ok {
+			tiledata[t] = td
+		} else {
+			f.types[td.name] = addTileData(td)
+		}
+	}
+	f.modTime = info.ModTime()
+
+	return nil
+}
+
+//Turns hot reloading on or off. While on, the game loop checks once a second whether any files loaded
+//with LoadTileDataFile() have changed and reloads them. Changes to visuals show up by themselves, but
+//if passability or transparency change you'll want to recompute FOV and lighting: listen for
+//EV_TILEDATA_RELOADED.
+func WatchTileData(watch bool) {
+	tileDataWatch = watch
+}
+
+//Reloads any tile data files that have changed since they were last loaded, emitting an
+//EV_TILEDATA_RELOADED event (with the path as the message) for each. Files with errors are left as
+//they were.
+func ReloadTileData() error {
+	var errs []string
+	for _, f := range tileDataFiles {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !info.ModTime().After(f.modTime) {
+			continue
+		}
+
+		if err := LoadTileDataFile(f.path); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		PushEvent(NewEvent(EV_TILEDATA_RELOADED, f.path))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Could not reload tile data: %s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+//Run by the game loop every frame.
+func pollTileData() {
+	if !tileDataWatch || time.Since(tileDataLastCheck) < time.Second {
+		return
+	}
+
+	tileDataLastCheck = time.Now()
+	if err := ReloadTileData(); err != nil {
+		LogError(err.Error())
+	}
+}
+
+//Parses and validates the contents of a tile data file. path is just for error messages.
+func parseTileData(path string, data []byte) ([]tileTypeData, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, jsonError(path, data, err, dec.InputOffset())
+	} else if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, TileDataErrors{{path, 1, "tile data must be a list of tile types"}}
+	}
+
+	var tds []tileTypeData
+	var errs TileDataErrors
+	names := make(map[string]int) //line each name was first defined on
+
+	for dec.More() {
+		offset := dec.InputOffset()
+		var raw map[string]json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, append(errs, jsonError(path, data, err, offset)...)
+		}
+
+		line := lineAt(data, offset)
+		td, msgs := parseTileEntry(raw)
+		for _, msg := range msgs {
+			errs = append(errs, TileDataError{path, line, msg})
+		}
+
+		if first, ok := names[td.name]; ok && td.name != "" {
+			errs = append(errs, TileDataError{path, line, fmt.Sprintf("duplicate tile type %q (first defined on line %d)", td.name, first)})
+		} else {
+			names[td.name] = line
+		}
+		tds = append(tds, td)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, append(errs, jsonError(path, data, err, dec.InputOffset())...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return tds, nil
+}
+
+//Builds a tile type from a decoded entry, returning a list of everything wrong with it.
+func parseTileEntry(raw map[string]json.RawMessage) (td tileTypeData, errs []string) {
+	field := func(key string, v interface{}) {
+		if r, ok := raw[key]; ok {
+			if err := json.Unmarshal(r, v); err != nil {
+				errs = append(errs, fmt.Sprintf("bad value for %q: %s", key, err.Error()))
+			}
+			delete(raw, key)
+		}
+	}
+
+	var glyph int
+	var colour, back tileColour = tileColour(COL_WHITE), tileColour(COL_BLACK)
+	var variants []struct {
+		Glyph      *int
+		Colour     *tileColour
+		BackColour *tileColour `json:"back_colour"`
+	}
+	td.moveCost = 1
+
+	field("name", &td.name)
+	field("passable", &td.passable)
+	field("transparent", &td.transparent)
+	field("glyph", &glyph)
+	field("colour", &colour)
+	field("back_colour", &back)
+	field("move_cost", &td.moveCost)
+	field("description", &td.desc)
+	field("variants", &variants)
+
+	if td.name == "" {
+		errs = append(errs, "tile type has no name")
+	}
+	if glyph < 0 || glyph > 255 {
+		errs = append(errs, fmt.Sprintf("glyph %d out of range (0-255)", glyph))
+	}
+	if td.moveCost < 1 {
+		errs = append(errs, fmt.Sprintf("move_cost must be at least 1 (got %d)", td.moveCost))
+	}
+
+	td.vis = Visuals{glyph, uint32(colour), uint32(back)}
+	for i, v := range variants {
+		vis := td.vis
+		if v.Glyph != nil {
+			if *v.Glyph < 0 || *v.Glyph > 255 {
+				errs = append(errs, fmt.Sprintf("variant %d: glyph %d out of range (0-255)", i+1, *v.Glyph))
+			}
+			vis.Glyph = *v.Glyph
+		}
+		if v.Colour != nil {
+			vis.ForeColour = uint32(*v.Colour)
+		}
+		if v.BackColour != nil {
+			vis.BackColour = uint32(*v.BackColour)
+		}
+		td.variants = append(td.variants, vis)
+	}
+
+	//anything left over is a custom property
+	for key, r := range raw {
+		var p interface{}
+		if err := json.Unmarshal(r, &p); err != nil {
+			errs = append(errs, fmt.Sprintf("bad value for %q: %s", key, err.Error()))
+			continue
+		}
+		if td.properties == nil {
+			td.properties = make(map[string]interface{})
+		}
+		td.properties[key] = p
+	}
+
+	return
+}
+
+//Colours in tile data files can be numbers or hex strings.
+type tileColour uint32
+
+func (c *tileColour) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n uint32
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("colours must be numbers or \"#RRGGBB\" strings")
+		}
+		*c = tileColour(n)
+		return nil
+	}
+
+	hex := strings.TrimPrefix(strings.TrimPrefix(s, "#"), "0x")
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || (len(hex) != 6 && len(hex) != 8) {
+		return fmt.Errorf("bad colour %q, should be \"#RRGGBB\" or \"#AARRGGBB\"", s)
+	}
+	if len(hex) == 6 {
+		n |= 0xFF000000
+	}
+
+	*c = tileColour(n)
+	return nil
+}
+
+//Converts a json error into a TileDataError, finding the line it happened on if possible.
+func jsonError(path string, data []byte, err error, offset int64) TileDataErrors {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+
+	return TileDataErrors{{path, lineAt(data, offset), err.Error()}}
+}
+
+//Returns the line number of the first token at or after offset.
+func lineAt(data []byte, offset int64) int {
+	i := Clamp(int(offset), 0, len(data))
+	for i < len(data) && strings.ContainsRune(" \t\r\n,", rune(data[i])) {
+		i++
+	}
+	if i == len(data) {
+		i = Clamp(int(offset), 0, len(data))
+	}
+
+	return bytes.Count(data[:i], []byte("\n")) + 1
+}
@@ -3,6 +3,14 @@ package burl
 type TileMap struct {
 	Width, Height int
 	Tiles         []Tile
+
+	ambient        uint32 //ambient light colour, see SetAmbientLight()
+	ambientRegions []ambientRegion
+	lights         *LightManager //if set, handles all the lighting. see NewLightManager()
+	index          *entityIndex  //built the first time entities are searched for. see spatial.go
+
+	world  *ChunkMap //set if this map is a chunk of a ChunkMap, so light can reach into the chunks around it
+	origin Coord     //world coords of the chunk's top left tile
 }
 
 func NewMap(w, h int) *TileMap {
@@ -16,6 +24,9 @@ func (m *TileMap) Dims() (int, int) {
 func (m *TileMap) ChangeTileType(x, y, tile int) {
 	if CheckBounds(x, y, m.Width, m.Height) {
 		m.Tiles[y*m.Width+x].TileType = tile
+		if m.lights != nil {
+			m.lights.MarkDirty(x, y)
+		}
 	}
 }
 
@@ -37,33 +48,112 @@ func (m *TileMap) GetTile(x, y int) Tile {
 
 func (m *TileMap) SetTile(x, y int, t Tile) {
 	if CheckBounds(x, y, m.Width, m.Height) {
+		if m.index != nil {
+			for _, e := range m.Tiles[x+y*m.Width].GetEntities() {
+				m.index.remove(e)
+			}
+			for _, e := range t.GetEntities() {
+				m.index.add(e, x, y)
+			}
+		}
 		m.Tiles[x+y*m.Width] = t
+		if m.lights != nil {
+			m.lights.MarkDirty(x, y)
+		}
 	}
 }
 
+//Adds an entity to the tile at (x, y). Only one blocking entity can be on a tile at a time, but
+//there's no limit to the number of non-blocking ones.
 func (m *TileMap) AddEntity(x, y int, e Entity) {
+	if m.placeEntity(x, y, e) && m.index != nil {
+		m.index.add(e, x, y)
+	}
+}
+
+func (m *TileMap) placeEntity(x, y int, e Entity) bool {
 	if CheckBounds(x, y, m.Width, m.Height) {
-		m.Tiles[x+y*m.Width].entity = e
-		m.ShadowCast(x, y, e.GetLight().Strength, Lighten)
+		t := &m.Tiles[x+y*m.Width]
+		if isBlocking(e) {
+			if t.entity != nil {
+				LogError("Tried to add blocking entity to occupied tile at ", x, ", ", y)
+				return false
+			}
+			t.entity = e
+		} else {
+			t.entities = append(t.entities, e)
+		}
+		m.addLight(x, y, e)
+		return true
 	}
+	return false
 }
 
+//Removes the blocking entity from the tile at (x, y), if there is one.
 func (m *TileMap) RemoveEntity(x, y int) {
-	if CheckBounds(x, y, m.Width, m.Height) {
-		m.ShadowCast(x, y, m.Tiles[x+y*m.Width].entity.GetLight().Strength, Darken)
-		m.Tiles[x+y*m.Width].entity = nil
+	if CheckBounds(x, y, m.Width, m.Height) && m.Tiles[x+y*m.Width].entity != nil {
+		m.RemoveEntityInstance(x, y, m.Tiles[x+y*m.Width].entity)
+	}
+}
+
+//Removes the particular entity e from the tile at (x, y), blocking or not. Does nothing if e isn't there.
+func (m *TileMap) RemoveEntityInstance(x, y int, e Entity) {
+	if m.takeEntity(x, y, e) && m.index != nil {
+		m.index.remove(e)
 	}
 }
 
+func (m *TileMap) takeEntity(x, y int, e Entity) bool {
+	if !CheckBounds(x, y, m.Width, m.Height) || e == nil {
+		return false
+	}
+
+	t := &m.Tiles[x+y*m.Width]
+	if t.entity == e {
+		t.entity = nil
+		m.removeLight(x, y, e)
+		return true
+	}
+
+	for i := range t.entities {
+		if t.entities[i] == e {
+			t.entities = append(t.entities[:i], t.entities[i+1:]...)
+			m.removeLight(x, y, e)
+			return true
+		}
+	}
+
+	return false
+}
+
+//Moves the blocking entity at (x, y) by (dx, dy). If the destination already has a blocking entity,
+//nothing happens.
 func (m *TileMap) MoveEntity(x, y, dx, dy int) {
-	e := m.Tiles[x+y*m.Width].entity
+	e := m.GetEntity(x, y)
 	if e != nil {
-		m.RemoveEntity(x, y)
-		m.AddEntity(x+dx, y+dy, e)
+		m.MoveEntityInstance(x, y, dx, dy, e)
+	}
+}
 
+//Moves the particular entity e on tile (x, y) by (dx, dy). If e is blocking and the destination already
+//has a blocking entity, or e is not on tile (x, y), nothing happens.
+func (m *TileMap) MoveEntityInstance(x, y, dx, dy int, e Entity) {
+	if !CheckBounds(x+dx, y+dy, m.Width, m.Height) || !m.HasEntity(x, y, e) {
+		return
+	}
+
+	if isBlocking(e) && m.Tiles[x+dx+(y+dy)*m.Width].entity != nil {
+		return
+	}
+
+	m.takeEntity(x, y, e)
+	m.placeEntity(x+dx, y+dy, e)
+	if m.index != nil {
+		m.index.move(e, x+dx, y+dy)
 	}
 }
 
+//Returns the blocking entity at (x, y), or nil if there isn't one.
 func (m *TileMap) GetEntity(x, y int) Entity {
 	if CheckBounds(x, y, m.Width, m.Height) {
 		return m.Tiles[x+y*m.Width].entity
@@ -72,25 +162,107 @@ func (m *TileMap) GetEntity(x, y int) Entity {
 	}
 }
 
-// func (m *TileMap) AddItem(x, y int, i *Item) {
-// 	if CheckBounds(x, y, m.Width, m.Height) && i != nil {
-// 		m.Tiles[x+y*m.Width].Item = i
-// 	}
-// }
+//Returns all the entities at (x, y): the blocking one first (if there is one), then the non-blocking
+//ones in the order they were added.
+func (m *TileMap) GetEntities(x, y int) []Entity {
+	if CheckBounds(x, y, m.Width, m.Height) {
+		return m.Tiles[x+y*m.Width].GetEntities()
+	} else {
+		return nil
+	}
+}
+
+//Reports whether entity e is on the tile at (x, y).
+func (m *TileMap) HasEntity(x, y int, e Entity) bool {
+	for _, te := range m.GetEntities(x, y) {
+		if te == e {
+			return true
+		}
+	}
+	return false
+}
 
-// func (m *TileMap) RemoveItem(x, y int) {
-// 	if CheckBounds(x, y, m.Width, m.Height) {
-// 		m.Tiles[x+y*m.Width].Item = nil
-// 	}
-// }
+//Puts an item on top of the item stack at (x, y).
+func (m *TileMap) AddItem(x, y int, i Item) {
+	if CheckBounds(x, y, m.Width, m.Height) && i != nil {
+		m.Tiles[x+y*m.Width].items = append(m.Tiles[x+y*m.Width].items, i)
+		m.addLight(x, y, i)
+	}
+}
 
-// func (m *TileMap) GetItem(x, y int) *Item {
-// 	if CheckBounds(x, y, m.Width, m.Height) {
-// 		return m.Tiles[x+y*m.Width].Item
-// 	} else {
-// 		return nil
-// 	}
-// }
+//Removes the particular item i from the stack at (x, y). Does nothing if i isn't there.
+func (m *TileMap) RemoveItem(x, y int, i Item) {
+	if !CheckBounds(x, y, m.Width, m.Height) {
+		return
+	}
+
+	t := &m.Tiles[x+y*m.Width]
+	for n := range t.items {
+		if t.items[n] == i {
+			t.items = append(t.items[:n], t.items[n+1:]...)
+			m.removeLight(x, y, i)
+			return
+		}
+	}
+}
+
+//Removes and returns the item on top of the stack at (x, y). Returns nil if there are no items.
+func (m *TileMap) PopItem(x, y int) Item {
+	i := m.GetTopItem(x, y)
+	if i != nil {
+		m.RemoveItem(x, y, i)
+	}
+	return i
+}
+
+//Returns the stack of items at (x, y), bottom to top.
+func (m *TileMap) GetItems(x, y int) []Item {
+	if CheckBounds(x, y, m.Width, m.Height) {
+		return m.Tiles[x+y*m.Width].GetItems()
+	} else {
+		return nil
+	}
+}
+
+//Returns the item on top of the stack at (x, y), or nil if there isn't one.
+func (m *TileMap) GetTopItem(x, y int) Item {
+	if items := m.GetItems(x, y); len(items) > 0 {
+		return items[len(items)-1]
+	}
+	return nil
+}
+
+//Lights the map around (x, y) if o gives off light.
+func (m *TileMap) addLight(x, y int, o interface{}) {
+	if le, ok := o.(LightEmitter); ok {
+		if m.lights != nil {
+			m.lights.addEmitter(x, y, le)
+			return
+		}
+		l := le.GetLight()
+		if m.world != nil {
+			m.world.castLight(m.origin.X+x, m.origin.Y+y, l.Strength, LightenColour(l.Colour, l.Falloff))
+			return
+		}
+		m.ShadowCast(x, y, l.Strength, LightenColour(l.Colour, l.Falloff))
+	}
+}
+
+//Removes the light o was contributing to the map around (x, y).
+func (m *TileMap) removeLight(x, y int, o interface{}) {
+	if le, ok := o.(LightEmitter); ok {
+		if m.lights != nil {
+			m.lights.removeEmitter(le)
+			return
+		}
+		l := le.GetLight()
+		if m.world != nil {
+			m.world.castLight(m.origin.X+x, m.origin.Y+y, l.Strength, DarkenColour(l.Colour, l.Falloff))
+			return
+		}
+		m.ShadowCast(x, y, l.Strength, DarkenColour(l.Colour, l.Falloff))
+	}
+}
 
 //For testing purposes.
 func (m *TileMap) ChangeTileColour(x, y int, c uint32) {
@@ -117,17 +289,21 @@ func (m *TileMap) SetVisible(x, y, tick int) {
 func (m *TileMap) ClearLights() {
 	for i, _ := range m.Tiles {
 		m.Tiles[i].Light.Bright = 0
+		m.Tiles[i].Light.R, m.Tiles[i].Light.G, m.Tiles[i].Light.B = 0, 0, 0
 	}
 }
 
-//Basic unit for the world. Holds a type (grass, wall, etc), a list of contained items (dropped weapons),
-//and a pointer to an Entity if one is standing there. Eventually will hold pathfinding information too.
+//Basic unit for the world. Holds a type (grass, wall, etc), a stack of contained items (dropped weapons),
+//the blocking Entity standing there if there is one, and any number of non-blocking entities. Eventually
+//will hold pathfinding information too. NOTE: Tiles hold their entities and items in slices, so copies of
+//a tile share them. Use the TileMap functions to add and remove things.
 type Tile struct {
 	TileType, Variant int //
-	entity            Entity
-	LastVisible       int // Records the last tick that this tile was seen
+	entity            Entity   //blocking entity
+	entities          []Entity //non-blocking entities, in the order they were added
+	items             []Item   //item stack. last item is on top.
+	LastVisible       int      // Records the last tick that this tile was seen
 	Light             TileLight
-	//Item              *Item
 }
 
 func (t Tile) Passable() bool {
@@ -138,17 +314,63 @@ func (t Tile) Transparent() bool {
 	return IsTransparent(t.TileType)
 }
 
+//Empty tiles are passable and have nothing on them at all.
 func (t Tile) Empty() bool {
-	//return t.Entity == nil && t.Item == nil && IsPassable(t.tileType)
-	return t.entity == nil && IsPassable(t.TileType)
+	return t.entity == nil && len(t.entities) == 0 && len(t.items) == 0 && IsPassable(t.TileType)
 }
 
 func (t Tile) GetVisuals() Visuals {
-	return GetTileVisuals(t.TileType)
+	return GetTileVariantVisuals(t.TileType, t.Variant)
+}
+
+//Returns all entities on the tile, blocking entity first.
+func (t Tile) GetEntities() []Entity {
+	es := make([]Entity, 0, len(t.entities)+1)
+	if t.entity != nil {
+		es = append(es, t.entity)
+	}
+	return append(es, t.entities...)
+}
+
+//Returns the items on the tile, bottom to top.
+func (t Tile) GetItems() []Item {
+	return append([]Item(nil), t.items...)
+}
+
+//Returns the thing that should be drawn on top of the tile, or nil if there is nothing on it. Draw
+//priority is: the blocking entity, then the most recently added non-blocking entity, then the top
+//item of the stack.
+func (t Tile) TopDrawable() Drawable {
+	switch {
+	case t.entity != nil:
+		return t.entity
+	case len(t.entities) > 0:
+		return t.entities[len(t.entities)-1]
+	case len(t.items) > 0:
+		return t.items[len(t.items)-1]
+	default:
+		return nil
+	}
+}
+
+//Returns the visuals of the tile with whatever is on top of it (see TopDrawable()) drawn over it. If
+//the thing on top has no background colour (COL_NONE), the tile's background shows through.
+func (t Tile) GetCompositeVisuals() Visuals {
+	v := t.GetVisuals()
+	if d := t.TopDrawable(); d != nil {
+		dv := d.GetVisuals()
+		v.Glyph, v.ForeColour = dv.Glyph, dv.ForeColour
+		if dv.BackColour != COL_NONE {
+			v.BackColour = dv.BackColour
+		}
+	}
+	return v
 }
 
-//Light characteristics for each tile.
+//Light characteristics for each tile. Coloured light from all sources is accumulated in R, G and B, which
+//can go over 255 where lights overlap. Use GetColour() to get the final mixed colour.
 type TileLight struct {
-	Colour uint32
-	Bright int //Brightness level 0-255
+	Colour  uint32 //tint multiplied into the mixed light. COL_NONE for no tint.
+	Bright  int    //Brightness level 0-255
+	R, G, B int
 }
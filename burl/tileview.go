@@ -6,6 +6,9 @@ import "github.com/bennicholls/burl-E/reximage"
 type TileView struct {
 	UIElement
 	grid []Cell
+
+	Statuses *StatusManager //if set, entities are drawn with their status overlays. see StatusManager.ApplyOverlay()
+	frames   int            //number of times rendered, for cycling status overlays
 }
 
 func NewTileView(w, h, x, y, z int, bord bool) *TileView {
@@ -31,14 +34,89 @@ func (tv *TileView) Draw(x, y, glyph int, f, b uint32) {
 
 //Draws a drawable object on the tileview at coord (x, y). If (x, y) not in bounds, does nothing.
 func (tv *TileView) DrawObject(x, y int, d Drawable) {
-	tv.Draw(x, y, d.GetVisuals().Glyph, d.GetVisuals().ForeColour, d.GetVisuals().BackColour)
+	v := tv.statusOverlay(d, d.GetVisuals(), tv.frames)
+	tv.Draw(x, y, v.Glyph, v.ForeColour, v.BackColour)
+}
+
+//Draws a map tile on the tileview at coord (x, y), with whatever is on top of it. See Tile.TopDrawable()
+//for the drawing priority.
+func (tv *TileView) DrawTile(x, y int, t Tile) {
+	v := tv.statusOverlay(t.TopDrawable(), t.GetCompositeVisuals(), tv.frames)
+	tv.Draw(x, y, v.Glyph, v.ForeColour, v.BackColour)
+}
+
+//Applies the status overlays of d to v, if d is an entity and there's a StatusManager.
+func (tv *TileView) statusOverlay(d Drawable, v Visuals, tick int) Visuals {
+	if e, ok := d.(Entity); ok && tv.Statuses != nil {
+		return tv.Statuses.ApplyOverlay(e, v, tick)
+	}
+	return v
 }
 
 func (tv *TileView) DrawCircle(x, y, r, glyph int, f, b uint32) {
-	DrawCircle(Coord{x, y}, r,
-		func(x, y int) {
-			tv.Draw(x, y, glyph, f, b)
-		})
+	DrawCircle(Coord{x, y}, r, tv.drawFunc(glyph, f, b))
+}
+
+func (tv *TileView) DrawFilledCircle(x, y, r, glyph int, f, b uint32) {
+	DrawFilledCircle(Coord{x, y}, r, tv.drawFunc(glyph, f, b))
+}
+
+func (tv *TileView) DrawEllipse(x, y, rx, ry, glyph int, f, b uint32) {
+	DrawEllipse(Coord{x, y}, rx, ry, tv.drawFunc(glyph, f, b))
+}
+
+func (tv *TileView) DrawFilledEllipse(x, y, rx, ry, glyph int, f, b uint32) {
+	DrawFilledEllipse(Coord{x, y}, rx, ry, tv.drawFunc(glyph, f, b))
+}
+
+//Draws a line from (x1, y1) to (x2, y2).
+func (tv *TileView) DrawLine(x1, y1, x2, y2, glyph int, f, b uint32) {
+	DrawLine(Coord{x1, y1}, Coord{x2, y2}, tv.drawFunc(glyph, f, b))
+}
+
+//Draws the outline of a w by h rectangle with its top-left corner at (x, y).
+func (tv *TileView) DrawRect(x, y, w, h, glyph int, f, b uint32) {
+	DrawRect(Rect{w, h, x, y}, tv.drawFunc(glyph, f, b))
+}
+
+func (tv *TileView) DrawFilledRect(x, y, w, h, glyph int, f, b uint32) {
+	DrawFilledRect(Rect{w, h, x, y}, tv.drawFunc(glyph, f, b))
+}
+
+func (tv *TileView) DrawPolygon(points []Coord, glyph int, f, b uint32) {
+	DrawPolygon(points, tv.drawFunc(glyph, f, b))
+}
+
+func (tv *TileView) DrawFilledPolygon(points []Coord, glyph int, f, b uint32) {
+	DrawFilledPolygon(points, tv.drawFunc(glyph, f, b))
+}
+
+//Flood fills the area of identical cells connected to (x, y) with the provided visuals.
+func (tv *TileView) FloodFill(x, y, glyph int, f, b uint32) {
+	if !CheckBounds(x, y, tv.width, tv.height) {
+		return
+	}
+
+	target := tv.grid[y*tv.width+x]
+	tv.Draw(x, y, glyph, f, b)
+	if sameVisuals(tv.grid[y*tv.width+x], target) {
+		return //nothing changed, so filling would go on forever
+	}
+	tv.grid[y*tv.width+x] = target
+
+	FloodFill(Coord{x, y},
+		func(x, y int) bool {
+			return CheckBounds(x, y, tv.width, tv.height) && sameVisuals(tv.grid[y*tv.width+x], target)
+		},
+		tv.drawFunc(glyph, f, b))
+}
+
+//Returns a function that draws the provided visuals to the tileview. Used with the drawing
+//functions in draw.go.
+func (tv *TileView) drawFunc(glyph int, f, b uint32) func(x, y int) {
+	return func(x, y int) {
+		tv.Draw(x, y, glyph, f, b)
+	}
 }
 
 //draws a palette to the tileview, one colour per tile. stops when it hits the edge of the view object. dir is HORIZONTAL or VERTICAL.
@@ -59,6 +137,7 @@ func (tv *TileView) DrawPalette(x, y int, p Palette, dir int) {
 }
 
 func (tv *TileView) Render() {
+	tv.frames++
 	if tv.visible {
 		for i, p := range tv.grid {
 			console.ChangeCell(tv.x+i%tv.width, tv.y+i/tv.width, tv.z, p.Glyph, p.ForeColour, p.BackColour)
@@ -0,0 +1,165 @@
+package burl
+
+import "math/rand"
+
+//Transitions are effects that play when changing from one state to another (see
+//ChangeStateWithTransition()). While a transition is running both the outgoing and incoming states
+//are rendered each frame, and the transition composites the two canvases together cell by cell.
+//Input is not delivered to either state until the transition completes, at which point an
+//EV_TRANSITION_DONE event is emitted so the new state knows it can get to work.
+type Transition interface {
+	Init(w, h int) //called once when the transition starts, with the dimensions of the console.
+	Tick()
+	Done() bool
+	Composite(x, y int, out, in []Cell) Cell //produces the cell to be drawn at (x, y) this frame.
+}
+
+//Base transition object, handles timing. Compose transitions around this.
+type TransitionPrototype struct {
+	tick, duration int
+	width, height  int
+}
+
+func NewTransitionPrototype(duration int) TransitionPrototype {
+	return TransitionPrototype{duration: Max(duration, 1)}
+}
+
+func (tp *TransitionPrototype) Init(w, h int) {
+	tp.tick = 0
+	tp.width, tp.height = w, h
+}
+
+func (tp *TransitionPrototype) Tick() {
+	if tp.tick < tp.duration {
+		tp.tick++
+	}
+}
+
+func (tp TransitionPrototype) Done() bool {
+	return tp.tick >= tp.duration
+}
+
+//Returns the cell at (x, y) in buffer buf, or a blank cell if (x, y) is out of bounds.
+func (tp TransitionPrototype) cellAt(x, y int, buf []Cell) (c Cell) {
+	if CheckBounds(x, y, tp.width, tp.height) {
+		return buf[y*tp.width+x]
+	}
+
+	c.Clear()
+	return
+}
+
+//FadeTransition fades the outgoing state to a solid colour, then fades the new state in from that
+//colour. The classic fade-to-black.
+type FadeTransition struct {
+	TransitionPrototype
+	colour uint32
+}
+
+func NewFadeTransition(duration int, c uint32) *FadeTransition {
+	return &FadeTransition{NewTransitionPrototype(duration), c}
+}
+
+func (ft *FadeTransition) Composite(x, y int, out, in []Cell) Cell {
+	half := ft.duration / 2
+	if ft.tick < half {
+		return fadeCell(out[y*ft.width+x], ft.colour, ft.tick, half)
+	}
+
+	return fadeCell(in[y*ft.width+x], ft.colour, ft.duration-ft.tick, ft.duration-half)
+}
+
+//Lerps the colours of a cell towards colour col.
+func fadeCell(c Cell, col uint32, val, steps int) Cell {
+	c.ForeColour = LerpColour(c.ForeColour, col, val, steps)
+	c.BackColour = LerpColour(c.BackColour, col, val, steps)
+	return c
+}
+
+//WipeTransition sweeps a hard edge across the screen, revealing the new state behind it. (dx, dy)
+//is the direction the edge travels in, each component one of -1, 0 or 1. Diagonals work too.
+type WipeTransition struct {
+	TransitionPrototype
+	dx, dy int
+}
+
+func NewWipeTransition(duration, dx, dy int) *WipeTransition {
+	if dx == 0 && dy == 0 {
+		dx = 1
+	}
+	return &WipeTransition{NewTransitionPrototype(duration), Clamp(dx, -1, 1), Clamp(dy, -1, 1)}
+}
+
+func (wt *WipeTransition) Composite(x, y int, out, in []Cell) Cell {
+	pos, span := 0, 0
+	if wt.dx != 0 {
+		span += wt.width
+		if wt.dx > 0 {
+			pos += x
+		} else {
+			pos += wt.width - 1 - x
+		}
+	}
+	if wt.dy != 0 {
+		span += wt.height
+		if wt.dy > 0 {
+			pos += y
+		} else {
+			pos += wt.height - 1 - y
+		}
+	}
+
+	if pos < Lerp(0, span, wt.tick, wt.duration) {
+		return in[y*wt.width+x]
+	}
+
+	return out[y*wt.width+x]
+}
+
+//DissolveTransition replaces the outgoing state with the new one cell-by-cell in a random order.
+type DissolveTransition struct {
+	TransitionPrototype
+	order []int //order[i] is the step at which cell i is replaced
+}
+
+func NewDissolveTransition(duration int) *DissolveTransition {
+	return &DissolveTransition{TransitionPrototype: NewTransitionPrototype(duration)}
+}
+
+func (dt *DissolveTransition) Init(w, h int) {
+	dt.TransitionPrototype.Init(w, h)
+	dt.order = rand.Perm(w * h)
+}
+
+func (dt *DissolveTransition) Composite(x, y int, out, in []Cell) Cell {
+	if dt.order[y*dt.width+x] < Lerp(0, len(dt.order), dt.tick, dt.duration) {
+		return in[y*dt.width+x]
+	}
+
+	return out[y*dt.width+x]
+}
+
+//SlideTransition pushes the outgoing state off the screen while the new state slides in behind it.
+//(dx, dy) is the direction of motion, each component one of -1, 0, or 1.
+type SlideTransition struct {
+	TransitionPrototype
+	dx, dy int
+}
+
+func NewSlideTransition(duration, dx, dy int) *SlideTransition {
+	if dx == 0 && dy == 0 {
+		dx = 1
+	}
+	return &SlideTransition{NewTransitionPrototype(duration), Clamp(dx, -1, 1), Clamp(dy, -1, 1)}
+}
+
+func (st *SlideTransition) Composite(x, y int, out, in []Cell) Cell {
+	sx := Lerp(0, st.width, st.tick, st.duration) * st.dx
+	sy := Lerp(0, st.height, st.tick, st.duration) * st.dy
+
+	if CheckBounds(x-sx, y-sy, st.width, st.height) {
+		return out[(y-sy)*st.width+x-sx]
+	}
+
+	return st.cellAt(x-sx+st.width*st.dx, y-sy+st.height*st.dy, in)
+}
@@ -0,0 +1,199 @@
+package burl
+
+//Bitset is a fixed-size set of bits, one per tile in a map. Nice and compact for FOV results.
+type Bitset []uint64
+
+func NewBitset(n int) Bitset {
+	return make(Bitset, (n+63)/64)
+}
+
+func (b Bitset) Set(i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+func (b Bitset) Unset(i int) {
+	b[i/64] &^= 1 << uint(i%64)
+}
+
+func (b Bitset) Get(i int) bool {
+	return b[i/64]&(1<<uint(i%64)) != 0
+}
+
+//Unsets all bits.
+func (b Bitset) Clear() {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+//Vision is the field of view and map memory of a single observer. Compute() it whenever the observer
+//moves (or the map changes), then ask it what the observer can see. Tiles the observer has seen are
+//remembered as they looked at the time: terrain and items, but not entities since those move around.
+//Visions aren't saved with their map (see SaveMap()), so observers forget what they've seen on reload.
+type Vision struct {
+	tileMap *TileMap
+	FOV     FOV
+	Radius  int
+	X, Y    int //where the vision was last computed from
+
+	visible  Bitset
+	seen     Bitset
+	memory   []Visuals
+	lastSeen []int
+}
+
+//Creates a new vision for an observer on map m. If fov is nil, uses the standard ShadowCaster.
+func NewVision(m *TileMap, radius int, fov FOV) *Vision {
+	if fov == nil {
+		fov = ShadowCaster{}
+	}
+
+	return &Vision{
+		tileMap:  m,
+		FOV:      fov,
+		Radius:   radius,
+		visible:  NewBitset(len(m.Tiles)),
+		seen:     NewBitset(len(m.Tiles)),
+		memory:   make([]Visuals, len(m.Tiles)),
+		lastSeen: make([]int, len(m.Tiles)),
+	}
+}
+
+//Recomputes what the observer can see from (x, y), and updates their memory of everything in view.
+//Observers off the map can't see anything.
+func (v *Vision) Compute(x, y, tick int) {
+	v.X, v.Y = x, y
+	v.visible.Clear()
+	if !CheckBounds(x, y, v.tileMap.Width, v.tileMap.Height) {
+		return
+	}
+	v.FOV.Cast(v.tileMap, x, y, v.Radius, func(m *TileMap, x, y, d, r int) {
+		i := x + y*m.Width
+		v.visible.Set(i)
+		v.seen.Set(i)
+		v.lastSeen[i] = tick
+		v.memory[i] = rememberedVisuals(m.Tiles[i])
+	})
+}
+
+//The visuals of the parts of a tile that are worth remembering: the terrain and the top item.
+func rememberedVisuals(t Tile) Visuals {
+	v := t.GetVisuals()
+	if len(t.items) > 0 {
+		iv := t.items[len(t.items)-1].GetVisuals()
+		v.Glyph, v.ForeColour = iv.Glyph, iv.ForeColour
+		if iv.BackColour != COL_NONE {
+			v.BackColour = iv.BackColour
+		}
+	}
+	return v
+}
+
+//Reports whether the observer can currently see (x, y).
+func (v *Vision) CanSee(x, y int) bool {
+	return CheckBounds(x, y, v.tileMap.Width, v.tileMap.Height) && v.visible.Get(x+y*v.tileMap.Width)
+}
+
+//Reports whether the observer has ever seen (x, y).
+func (v *Vision) Remembers(x, y int) bool {
+	return CheckBounds(x, y, v.tileMap.Width, v.tileMap.Height) && v.seen.Get(x+y*v.tileMap.Width)
+}
+
+//Returns the visuals of (x, y) as the observer last saw them. Returns false if they've never seen it.
+func (v *Vision) GetMemory(x, y int) (Visuals, bool) {
+	if !v.Remembers(x, y) {
+		return Visuals{}, false
+	}
+	return v.memory[x+y*v.tileMap.Width], true
+}
+
+//Returns the tick (x, y) was last seen on, or 0 if never seen.
+func (v *Vision) LastSeen(x, y int) int {
+	if v.Remembers(x, y) {
+		return v.lastSeen[x+y*v.tileMap.Width]
+	}
+	return 0
+}
+
+//Wipes the observer's memory of the map. Amnesia potions, etc.
+func (v *Vision) Forget() {
+	v.seen.Clear()
+	for i := range v.lastSeen {
+		v.lastSeen[i] = 0
+	}
+}
+
+//Returns the coords of all tiles currently visible to the observer.
+func (v *Vision) VisibleTiles() (tiles []Coord) {
+	for i := range v.memory {
+		if v.visible.Get(i) {
+			tiles = append(tiles, Coord{i % v.tileMap.Width, i / v.tileMap.Width})
+		}
+	}
+	return
+}
+
+//VisionLayer keeps track of the Visions of any number of observers on a map.
+type VisionLayer struct {
+	tileMap   *TileMap
+	visions   map[Entity]*Vision
+	observers []Entity //kept so iteration order is stable
+}
+
+func NewVisionLayer(m *TileMap) *VisionLayer {
+	return &VisionLayer{tileMap: m, visions: make(map[Entity]*Vision)}
+}
+
+//Registers an observer and returns their new vision. If fov is nil, uses the standard ShadowCaster.
+//If the observer is already registered, their vision is replaced.
+func (vl *VisionLayer) Add(observer Entity, radius int, fov FOV) *Vision {
+	if _, ok := vl.visions[observer]; !ok {
+		vl.observers = append(vl.observers, observer)
+	}
+	vl.visions[observer] = NewVision(vl.tileMap, radius, fov)
+	return vl.visions[observer]
+}
+
+func (vl *VisionLayer) Remove(observer Entity) {
+	if _, ok := vl.visions[observer]; !ok {
+		return
+	}
+
+	delete(vl.visions, observer)
+	for i := range vl.observers {
+		if vl.observers[i] == observer {
+			vl.observers = append(vl.observers[:i], vl.observers[i+1:]...)
+			break
+		}
+	}
+}
+
+//Returns the vision of an observer, or nil if they aren't registered.
+func (vl *VisionLayer) Get(observer Entity) *Vision {
+	return vl.visions[observer]
+}
+
+//Recomputes an observer's vision from (x, y).
+func (vl *VisionLayer) Update(observer Entity, x, y, tick int) {
+	if v := vl.visions[observer]; v != nil {
+		v.Compute(x, y, tick)
+	}
+}
+
+//Reports whether observer can see (x, y). Unregistered observers can't see anything.
+func (vl *VisionLayer) CanSee(observer Entity, x, y int) bool {
+	if v := vl.visions[observer]; v != nil {
+		return v.CanSee(x, y)
+	}
+	return false
+}
+
+//Returns all observers who can currently see (x, y), in the order they were added.
+func (vl *VisionLayer) SeenBy(x, y int) (observers []Entity) {
+	for _, o := range vl.observers {
+		if vl.visions[o].CanSee(x, y) {
+			observers = append(observers, o)
+		}
+	}
+	return
+}
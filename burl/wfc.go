@@ -0,0 +1,482 @@
+package burl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+//WFC is a Wave Function Collapse generator using the overlapping model. It learns every NxN pattern
+//in a sample grid, then builds new grids where every NxN area is one of those patterns, in roughly
+//the same proportions as the sample. Draw a small sample in REXPaint (or build one in a TileMap) and
+//get as much map in the same style as you want.
+//
+//Generation picks the most constrained spot, collapses it to a random pattern, and propagates the
+//consequences. If that leads to a contradiction it backtracks and tries something else.
+type WFC struct {
+	n             int
+	patterns      [][]int //each n*n, row by row
+	weights       []float64
+	propagator    [4][][]int //propagator[d][p] lists the patterns that can sit one step in direction d from p
+	maxBacktracks int
+}
+
+type WFCOptions struct {
+	N              int  //size of the patterns. 2 is fast and loose, 3 (the default) is usually right, 4+ copies the sample closely
+	PeriodicSample bool //the sample wraps around at the edges
+	Symmetry       int  //how many orientations of the sample to learn from: 1 (default, as drawn), 2 (plus mirrored), up to 8 (all rotations and mirrors)
+	MaxBacktracks  int  //give up after backtracking this many times. default 1000
+}
+
+//WFCConstraint restricts what can go where in the output. It reports whether tile is allowed at
+//(x, y) in an output of size (w, h).
+type WFCConstraint func(x, y, w, h, tile int) bool
+
+//Constrains the edges of the output to be a particular tile. Make sure the sample has some of that tile
+//along its edges too, or there'll be no patterns that fit!
+func WFCBorder(tile int) WFCConstraint {
+	return func(x, y, w, h, t int) bool {
+		return (x != 0 && y != 0 && x != w-1 && y != h-1) || t == tile
+	}
+}
+
+//Constrains a single cell of the output to be a particular tile.
+func WFCFixed(cx, cy, tile int) WFCConstraint {
+	return func(x, y, w, h, t int) bool {
+		return x != cx || y != cy || t == tile
+	}
+}
+
+//directions used for propagation, and their opposites (d+2)%4
+var wfcDirs = [4]Coord{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+
+//Creates a generator from a sample grid of size (w, h). Values in the sample can be anything, usually
+//tile types.
+func NewWFC(sample []int, w, h int, opts WFCOptions) (*WFC, error) {
+	if opts.N <= 0 {
+		opts.N = 3
+	}
+	if opts.Symmetry <= 0 {
+		opts.Symmetry = 1
+	}
+	if opts.MaxBacktracks <= 0 {
+		opts.MaxBacktracks = 1000
+	}
+	n := opts.N
+
+	if len(sample) != w*h || w < n || h < n {
+		return nil, fmt.Errorf("WFC sample must be at least %dx%d", n, n)
+	}
+
+	wfc := &WFC{n: n, maxBacktracks: opts.MaxBacktracks}
+	index := make(map[string]int)
+
+	maxX, maxY := w-n+1, h-n+1
+	if opts.PeriodicSample {
+		maxX, maxY = w, h
+	}
+
+	for y := 0; y < maxY; y++ {
+		for x := 0; x < maxX; x++ {
+			p := make([]int, n*n)
+			for i := range p {
+				p[i] = sample[(x+i%n)%w+((y+i/n)%h)*w]
+			}
+
+			for _, v := range patternOrientations(p, n)[:Min(opts.Symmetry, 8)] {
+				key := fmt.Sprint(v)
+				if i, ok := index[key]; ok {
+					wfc.weights[i]++
+				} else {
+					index[key] = len(wfc.patterns)
+					wfc.patterns = append(wfc.patterns, v)
+					wfc.weights = append(wfc.weights, 1)
+				}
+			}
+		}
+	}
+
+	for d, dir := range wfcDirs {
+		wfc.propagator[d] = make([][]int, len(wfc.patterns))
+		for p := range wfc.patterns {
+			for q := range wfc.patterns {
+				if wfc.agrees(p, q, dir.X, dir.Y) {
+					wfc.propagator[d][p] = append(wfc.propagator[d][p], q)
+				}
+			}
+		}
+	}
+
+	return wfc, nil
+}
+
+//Creates a generator using the tile types of a map as the sample.
+func NewWFCFromMap(m *TileMap, opts WFCOptions) (*WFC, error) {
+	sample := make([]int, len(m.Tiles))
+	for i, t := range m.Tiles {
+		sample[i] = t.TileType
+	}
+	return NewWFC(sample, m.Width, m.Height, opts)
+}
+
+//Creates a generator using a prefab as the sample. Use LoadPrefabFromXP() to draw samples in REXPaint.
+//Every cell of the prefab must have a tile.
+func NewWFCFromPrefab(p *Prefab, opts WFCOptions) (*WFC, error) {
+	for _, t := range p.Tiles {
+		if t < 0 {
+			return nil, errors.New("WFC sample prefab has empty cells")
+		}
+	}
+	return NewWFC(p.Tiles, p.Width, p.Height, opts)
+}
+
+//Returns the 8 orientations of an nxn pattern: as is, mirrored, rotated, rotated and mirrored, etc.
+func patternOrientations(p []int, n int) [][]int {
+	transform := func(p []int, f func(x, y int) int) []int {
+		r := make([]int, n*n)
+		for i := range r {
+			r[i] = p[f(i%n, i/n)]
+		}
+		return r
+	}
+	rotate := func(p []int) []int { return transform(p, func(x, y int) int { return n - 1 - y + x*n }) }
+	mirror := func(p []int) []int { return transform(p, func(x, y int) int { return n - 1 - x + y*n }) }
+
+	os := make([][]int, 8)
+	os[0] = p
+	for i := 1; i < 8; i++ {
+		if i%2 == 1 {
+			os[i] = mirror(os[i-1])
+		} else {
+			os[i] = rotate(os[i-2])
+		}
+	}
+	return os
+}
+
+//Reports whether pattern q can be placed at offset (dx, dy) from pattern p, ie. they agree where they overlap.
+func (wfc *WFC) agrees(p, q, dx, dy int) bool {
+	n := wfc.n
+	for y := Max(0, dy); y < Min(n, n+dy); y++ {
+		for x := Max(0, dx); x < Min(n, n+dx); x++ {
+			if wfc.patterns[p][x+y*n] != wfc.patterns[q][x-dx+(y-dy)*n] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//Returns the number of distinct patterns learned from the sample.
+func (wfc *WFC) NumPatterns() int {
+	return len(wfc.patterns)
+}
+
+//Generates a new w x h grid in the style of the sample. Returns an error if the constraints can't be
+//met or generation fails even after backtracking (try another seed).
+func (wfc *WFC) Generate(w, h int, seed int64, constraints ...WFCConstraint) ([]int, error) {
+	if w < wfc.n || h < wfc.n {
+		return nil, fmt.Errorf("WFC output must be at least %dx%d", wfc.n, wfc.n)
+	}
+
+	s := newWFCState(wfc, w-wfc.n+1, h-wfc.n+1, rand.New(rand.NewSource(seed)))
+
+	//apply constraints by banning patterns that would put the wrong thing somewhere
+	if len(constraints) > 0 {
+		for i := 0; i < s.w*s.h; i++ {
+			cx, cy := i%s.w, i/s.w
+			for p, pattern := range wfc.patterns {
+				for j, t := range pattern {
+					ok := true
+					for _, c := range constraints {
+						if !c(cx+j%wfc.n, cy+j/wfc.n, w, h, t) {
+							ok = false
+							break
+						}
+					}
+					if !ok {
+						if s.wave[i*s.t+p] {
+							s.ban(i, p)
+						}
+						break
+					}
+				}
+			}
+		}
+	}
+	if !s.propagate() {
+		if len(constraints) > 0 {
+			return nil, errors.New("WFC constraints can't be satisfied by the sample's patterns")
+		}
+		return nil, errors.New("WFC sample's patterns can't fill an output this size")
+	}
+
+	if err := s.run(); err != nil {
+		return nil, err
+	}
+
+	out := make([]int, w*h)
+	for i := range out {
+		x, y := i%w, i/w
+		cx, cy := Min(x, s.w-1), Min(y, s.h-1)
+		p := s.chosen(cx + cy*s.w)
+		out[i] = wfc.patterns[p][x-cx+(y-cy)*wfc.n]
+	}
+	return out, nil
+}
+
+//Generates a new map's worth of tile types in the style of the sample, filling the whole of m.
+func (wfc *WFC) GenerateMap(m *TileMap, seed int64, constraints ...WFCConstraint) error {
+	out, err := wfc.Generate(m.Width, m.Height, seed, constraints...)
+	if err != nil {
+		return err
+	}
+
+	for i, t := range out {
+		m.ChangeTileType(i%m.Width, i/m.Width, t)
+	}
+	return nil
+}
+
+//The state of a generation in progress. Every ban is recorded on a trail so it can be undone when
+//backtracking.
+type wfcState struct {
+	wfc  *WFC
+	w, h int //size of the wave, ie. the number of pattern positions
+	t    int //number of patterns
+	rng  *rand.Rand
+
+	wave    []bool //wave[i*t+p]: can pattern p still go at position i?
+	support []int  //support[(i*t+p)*4+d]: how many patterns in the neighbour of i in direction d allow p at i
+	count   []int  //patterns still possible at each position
+	sumW    []float64
+	sumWLog []float64
+
+	trail     []wfcBan
+	queue     []int //indices into trail of bans waiting to be propagated
+	decisions []wfcDecision
+}
+
+type wfcBan struct {
+	i, p       int
+	propagated bool
+}
+
+type wfcDecision struct {
+	mark int //trail length before the decision
+	i, p int
+}
+
+func newWFCState(wfc *WFC, w, h int, rng *rand.Rand) *wfcState {
+	t := len(wfc.patterns)
+	s := &wfcState{
+		wfc: wfc, w: w, h: h, t: t, rng: rng,
+		wave:    make([]bool, w*h*t),
+		support: make([]int, w*h*t*4),
+		count:   make([]int, w*h),
+		sumW:    make([]float64, w*h),
+		sumWLog: make([]float64, w*h),
+	}
+
+	var sumW, sumWLog float64
+	for _, wt := range wfc.weights {
+		sumW += wt
+		sumWLog += wt * math.Log(wt)
+	}
+
+	for i := 0; i < w*h; i++ {
+		s.count[i], s.sumW[i], s.sumWLog[i] = t, sumW, sumWLog
+		for p := 0; p < t; p++ {
+			s.wave[i*t+p] = true
+			for d := range wfcDirs {
+				if _, ok := s.neighbour(i, d); ok {
+					s.support[(i*t+p)*4+d] = len(wfc.propagator[d][p])
+				} else {
+					s.support[(i*t+p)*4+d] = -1 //no neighbour, never runs out of support
+				}
+			}
+		}
+	}
+
+	//patterns from the edges of a non-periodic sample can have nothing that goes next to them, so they
+	//start with no support and have to be banned up front. propagate() before doing anything else.
+	for i := 0; i < w*h; i++ {
+		for p := 0; p < t; p++ {
+			for d := range wfcDirs {
+				if s.support[(i*t+p)*4+d] == 0 {
+					s.ban(i, p)
+					break
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+func (s *wfcState) neighbour(i, d int) (int, bool) {
+	x, y := i%s.w+wfcDirs[d].X, i/s.w+wfcDirs[d].Y
+	return x + y*s.w, CheckBounds(x, y, s.w, s.h)
+}
+
+//Removes pattern p from position i. It gets propagated later.
+func (s *wfcState) ban(i, p int) {
+	s.wave[i*s.t+p] = false
+	wt := s.wfc.weights[p]
+	s.count[i]--
+	s.sumW[i] -= wt
+	s.sumWLog[i] -= wt * math.Log(wt)
+
+	s.queue = append(s.queue, len(s.trail))
+	s.trail = append(s.trail, wfcBan{i, p, false})
+}
+
+//Propagates all queued bans. Returns false if some position runs out of patterns.
+func (s *wfcState) propagate() bool {
+	for len(s.queue) > 0 {
+		b := &s.trail[s.queue[len(s.queue)-1]]
+		s.queue = s.queue[:len(s.queue)-1]
+		if s.count[b.i] == 0 {
+			s.queue = s.queue[:0]
+			return false
+		}
+
+		//the whole ban has to be propagated even if there's a contradiction partway through, since undo()
+		//puts back the support for every direction
+		b.propagated = true
+		i, p := b.i, b.p
+		contradiction := false
+		for d := range wfcDirs {
+			j, ok := s.neighbour(i, d)
+			if !ok {
+				continue
+			}
+			//patterns q at j that p supported lose that support
+			opp := (d + 2) % 4
+			for _, q := range s.wfc.propagator[d][p] {
+				k := (j*s.t+q)*4 + opp
+				s.support[k]--
+				if s.support[k] == 0 && s.wave[j*s.t+q] {
+					s.ban(j, q)
+					if s.count[j] == 0 {
+						contradiction = true
+					}
+				}
+			}
+		}
+
+		if contradiction {
+			s.queue = s.queue[:0]
+			return false
+		}
+	}
+
+	return true
+}
+
+//Undoes every ban made since the trail was mark long.
+func (s *wfcState) undo(mark int) {
+	for len(s.trail) > mark {
+		b := s.trail[len(s.trail)-1]
+		s.trail = s.trail[:len(s.trail)-1]
+
+		if b.propagated {
+			for d := range wfcDirs {
+				if j, ok := s.neighbour(b.i, d); ok {
+					for _, q := range s.wfc.propagator[d][b.p] {
+						s.support[(j*s.t+q)*4+(d+2)%4]++
+					}
+				}
+			}
+		}
+
+		wt := s.wfc.weights[b.p]
+		s.wave[b.i*s.t+b.p] = true
+		s.count[b.i]++
+		s.sumW[b.i] += wt
+		s.sumWLog[b.i] += wt * math.Log(wt)
+	}
+	s.queue = s.queue[:0]
+}
+
+//Collapses positions one at a time until everything is decided, backtracking on contradictions.
+func (s *wfcState) run() error {
+	backtracks := 0
+	for {
+		i := s.lowestEntropy()
+		if i == -1 {
+			return nil
+		}
+
+		p := s.pickPattern(i)
+		s.decisions = append(s.decisions, wfcDecision{len(s.trail), i, p})
+		s.collapse(i, p)
+
+		for !s.propagate() {
+			//contradiction. undo the last decision and rule out that choice, going further back if
+			//that causes a contradiction too.
+			if len(s.decisions) == 0 || backtracks >= s.wfc.maxBacktracks {
+				return errors.New("WFC generation failed, try a different seed")
+			}
+			backtracks++
+
+			d := s.decisions[len(s.decisions)-1]
+			s.decisions = s.decisions[:len(s.decisions)-1]
+			s.undo(d.mark)
+			s.ban(d.i, d.p)
+		}
+	}
+}
+
+//Bans every pattern at position i except p.
+func (s *wfcState) collapse(i, p int) {
+	for q := 0; q < s.t; q++ {
+		if q != p && s.wave[i*s.t+q] {
+			s.ban(i, q)
+		}
+	}
+}
+
+//Finds the undecided position with the lowest entropy, or -1 if everything is decided. A little noise
+//breaks ties randomly.
+func (s *wfcState) lowestEntropy() int {
+	best, bestEntropy := -1, math.Inf(1)
+	for i := range s.count {
+		if s.count[i] <= 1 {
+			continue
+		}
+
+		e := math.Log(s.sumW[i]) - s.sumWLog[i]/s.sumW[i] + s.rng.Float64()*1e-6
+		if e < bestEntropy {
+			best, bestEntropy = i, e
+		}
+	}
+	return best
+}
+
+//Picks one of the patterns still possible at position i, weighted by how often they appear in the sample.
+func (s *wfcState) pickPattern(i int) int {
+	r := s.rng.Float64() * s.sumW[i]
+	last := -1
+	for p := 0; p < s.t; p++ {
+		if !s.wave[i*s.t+p] {
+			continue
+		}
+		last = p
+		if r -= s.wfc.weights[p]; r < 0 {
+			return p
+		}
+	}
+	return last //rounding
+}
+
+//Returns the pattern position i collapsed to.
+func (s *wfcState) chosen(i int) int {
+	for p := 0; p < s.t; p++ {
+		if s.wave[i*s.t+p] {
+			return p
+		}
+	}
+	return 0
+}
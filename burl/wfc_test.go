@@ -0,0 +1,119 @@
+package burl
+
+import (
+	"math/rand"
+	"testing"
+)
+
+//Recomputes the support counts from the wave and compares them to the ones the state has been keeping
+//up to date. Only valid when every ban on the trail has been propagated.
+func checkWFCSupport(t *testing.T, s *wfcState) {
+	for i := 0; i < s.w*s.h; i++ {
+		for p := 0; p < s.t; p++ {
+			for d := range wfcDirs {
+				want := -1
+				if j, ok := s.neighbour(i, d); ok {
+					want = 0
+					for _, q := range s.wfc.propagator[d][p] {
+						if s.wave[j*s.t+q] {
+							want++
+						}
+					}
+				}
+				if got := s.support[(i*s.t+p)*4+d]; got != want {
+					t.Fatalf("support for pattern %d at %d in direction %d is %d, should be %d", p, i, d, got, want)
+				}
+			}
+		}
+	}
+}
+
+//Runs the same loop as wfcState.run(), checking the support counts after every backtrack.
+func TestWFCSupportAfterBacktrack(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	backtracks := 0
+
+	for iter := 0; iter < 300; iter++ {
+		sample := make([]int, 25)
+		for i := range sample {
+			sample[i] = r.Intn(3)
+		}
+		wfc, err := NewWFC(sample, 5, 5, WFCOptions{N: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := newWFCState(wfc, 9, 9, rand.New(rand.NewSource(int64(iter))))
+		if !s.propagate() {
+			continue
+		}
+	generate:
+		for n := 0; n < 1000; n++ {
+			i := s.lowestEntropy()
+			if i == -1 {
+				break
+			}
+			p := s.pickPattern(i)
+			s.decisions = append(s.decisions, wfcDecision{len(s.trail), i, p})
+			s.collapse(i, p)
+
+			for !s.propagate() {
+				if len(s.decisions) == 0 {
+					break generate //no solution, run() would give up here
+				}
+				backtracks++
+				d := s.decisions[len(s.decisions)-1]
+				s.decisions = s.decisions[:len(s.decisions)-1]
+				s.undo(d.mark)
+				checkWFCSupport(t, s)
+				s.ban(d.i, d.p)
+			}
+		}
+	}
+
+	if backtracks == 0 {
+		t.Fatal("no backtracking happened, so nothing was tested")
+	}
+}
+
+//Every NxN area of the output should be one of the sample's patterns, even when generation had to
+//backtrack or the sample has patterns along its edges that nothing fits next to.
+func TestWFCOutputPatterns(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	generated := 0
+	for iter := 0; iter < 100; iter++ {
+		sample := make([]int, 25)
+		for i := range sample {
+			sample[i] = r.Intn(3)
+		}
+		wfc, err := NewWFC(sample, 5, 5, WFCOptions{N: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := wfc.Generate(10, 10, int64(iter))
+		if err != nil {
+			continue
+		}
+		generated++
+
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 9; x++ {
+				found := false
+				for _, p := range wfc.patterns {
+					if p[0] == out[x+y*10] && p[1] == out[x+1+y*10] && p[2] == out[x+(y+1)*10] && p[3] == out[x+1+(y+1)*10] {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Fatalf("sample %d: area at %d,%d isn't a pattern from the sample", iter, x, y)
+				}
+			}
+		}
+	}
+
+	if generated == 0 {
+		t.Fatal("every sample failed to generate, so nothing was tested")
+	}
+}
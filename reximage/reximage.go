@@ -83,10 +83,31 @@ func (cd CellData) RGBA() (fore, back uint32) {
 }
 
 //Import imports an image from the xp file at the provided path. Returns the Imagedata and an error.
-//If an error is present, ImageData will be no good.
+//If an error is present, ImageData will be no good. All layers are flattened into one image.
 func Import(path string) (image ImageData, err error) {
-	image = ImageData{}
+	layers, err := ImportLayers(path)
+	if err != nil || len(layers) == 0 {
+		return
+	}
+
+	image = ImageData{layers[0].Width, layers[0].Height, make([]CellData, layers[0].Width*layers[0].Height)}
+
+	//paint from lowest layer to highest
+	for _, layer := range layers {
+		for i, c := range layer.Cells {
+			if !c.Undrawn() {
+				image.Cells[i] = c
+			}
+		}
+	}
+
+	return
+}
 
+//ImportLayers imports an image from the xp file at the provided path, keeping each layer separate.
+//Layers are ordered lowest first. Cells not drawn on in a layer are left as REXPaint stores them,
+//use CellData.Undrawn() to check for them. All layers must be the same size.
+func ImportLayers(path string) (layers []ImageData, err error) {
 	if !strings.HasSuffix(path, ".xp") {
 		err = errors.New("File is not an XP image.")
 		return
@@ -113,46 +134,40 @@ func Import(path string) (image ImageData, err error) {
 		return
 	}
 
-	//read into the first layer so we can get the image dimensions and initialize
-	//cell data
-	var w, h uint32
-	err = binary.Read(data, binary.LittleEndian, &w)
-	err = binary.Read(data, binary.LittleEndian, &h)
-	if err != nil {
-		return
-	}
-
-	image.Width, image.Height = int(w), int(h)
-	image.Cells = make([]CellData, image.Width*image.Height)
-
-	//read layers, painting from lowest layer to highest
-	for layer := 0; layer < int(numLayers); layer++ {
-		if layer != 0 {
-			//if reading subsequent layers, throw away the dimension
-			//bytes since we've already read them before
-			err = binary.Read(data, binary.LittleEndian, &w)
-			err = binary.Read(data, binary.LittleEndian, &h)
+	for l := 0; l < int(numLayers); l++ {
+		var w, h uint32
+		err = binary.Read(data, binary.LittleEndian, &w)
+		err = binary.Read(data, binary.LittleEndian, &h)
+		if err != nil {
+			return nil, err
+		}
+		if l > 0 && (int(w) != layers[0].Width || int(h) != layers[0].Height) {
+			return nil, errors.New("Layers in XP image are different sizes.")
 		}
 
-		for i := 0; i < image.Width*image.Height; i++ {
+		layer := ImageData{int(w), int(h), make([]CellData, int(w)*int(h))}
+		for i := 0; i < layer.Width*layer.Height; i++ {
 			//read bytes for each cell.
 			c := CellData{}
 			err = binary.Read(data, binary.LittleEndian, &c)
 			if err != nil {
-				return
+				return nil, err
 			}
 
-			//check for undrawn cell, identified by bgcolour = (255, 0, 255)
-			if c.R_b == 255 && c.G_b == 0 && c.B_b == 255 {
-				continue
-			} else {
-				//xp images are encoded in the totally insane column-major order for some reason,
-				//we correct that here (sorry Kyzrati, gotta put my foot down on this one)
-				x, y := i/image.Height, i%image.Height
-				image.Cells[y*image.Width+x] = c
-			}
+			//xp images are encoded in the totally insane column-major order for some reason,
+			//we correct that here (sorry Kyzrati, gotta put my foot down on this one)
+			x, y := i/layer.Height, i%layer.Height
+			layer.Cells[y*layer.Width+x] = c
 		}
+
+		layers = append(layers, layer)
 	}
 
 	return
 }
+
+// Undrawn reports whether the cell was left empty in REXPaint. Undrawn cells are
+// identified by a background colour of (255, 0, 255).
+func (cd CellData) Undrawn() bool {
+	return cd.R_b == 255 && cd.G_b == 0 && cd.B_b == 255
+}